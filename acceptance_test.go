@@ -18,6 +18,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
 )
 
 const (
@@ -29,7 +31,7 @@ const (
 // AcceptanceTestSuite provides setup/teardown for acceptance tests
 type AcceptanceTestSuite struct {
 	client     *s3.Client
-	uploader   S3Uploader
+	uploader   uploader.S3Uploader
 	bucketName string
 	ctx        context.Context
 }
@@ -66,14 +68,14 @@ func newAcceptanceTestSuite(t *testing.T) *AcceptanceTestSuite {
 	})
 
 	// Create uploader
-	uploader := NewS3UploaderWithClient(client)
+	up := uploader.NewS3UploaderWithClient(client)
 
 	// Generate unique bucket name
 	bucketName := fmt.Sprintf("%s%d", testBucketPrefix, time.Now().UnixNano())
 
 	suite := &AcceptanceTestSuite{
 		client:     client,
-		uploader:   uploader,
+		uploader:   up,
 		bucketName: bucketName,
 		ctx:        ctx,
 	}
@@ -163,11 +165,11 @@ func TestAcceptance_SingleFileUpload(t *testing.T) {
 
 	// Upload a simple file
 	content := "Hello, LocalStack!"
-	input := &UploadInput{
+	input := &uploader.UploadInput{
 		Bucket:      suite.bucketName,
 		Key:         "test/hello.txt",
 		Body:        strings.NewReader(content),
-		ContentType: stringPtr("text/plain"),
+		ContentType: aws.String("text/plain"),
 	}
 
 	output, err := suite.uploader.Upload(suite.ctx, input)
@@ -192,12 +194,12 @@ func TestAcceptance_UploadWithHeaders(t *testing.T) {
 	suite := newAcceptanceTestSuite(t)
 	defer suite.cleanup(t)
 
-	input := &UploadInput{
+	input := &uploader.UploadInput{
 		Bucket:       suite.bucketName,
 		Key:          "assets/style.css",
 		Body:         strings.NewReader("body { color: red; }"),
-		ContentType:  stringPtr("text/css"),
-		CacheControl: stringPtr("max-age=31536000"),
+		ContentType:  aws.String("text/css"),
+		CacheControl: aws.String("max-age=31536000"),
 	}
 
 	_, err := suite.uploader.Upload(suite.ctx, input)
@@ -233,11 +235,11 @@ func TestAcceptance_MultipleFilesUpload(t *testing.T) {
 	}
 
 	for key, content := range files {
-		input := &UploadInput{
+		input := &uploader.UploadInput{
 			Bucket:      suite.bucketName,
 			Key:         key,
 			Body:        strings.NewReader(content),
-			ContentType: stringPtr("text/plain"),
+			ContentType: aws.String("text/plain"),
 		}
 
 		_, err := suite.uploader.Upload(suite.ctx, input)
@@ -272,11 +274,11 @@ func TestAcceptance_ConcurrentUploads(t *testing.T) {
 		go func(n int) {
 			defer wg.Done()
 
-			input := &UploadInput{
+			input := &uploader.UploadInput{
 				Bucket:      suite.bucketName,
 				Key:         fmt.Sprintf("concurrent/file-%d.txt", n),
 				Body:        strings.NewReader(fmt.Sprintf("Content %d", n)),
-				ContentType: stringPtr("text/plain"),
+				ContentType: aws.String("text/plain"),
 			}
 
 			_, err := suite.uploader.Upload(suite.ctx, input)
@@ -313,11 +315,11 @@ func TestAcceptance_LargeFileUpload(t *testing.T) {
 		content[i] = byte(i % 256)
 	}
 
-	input := &UploadInput{
+	input := &uploader.UploadInput{
 		Bucket:      suite.bucketName,
 		Key:         "large/bigfile.bin",
 		Body:        bytes.NewReader(content),
-		ContentType: stringPtr("application/octet-stream"),
+		ContentType: aws.String("application/octet-stream"),
 	}
 
 	_, err := suite.uploader.Upload(suite.ctx, input)
@@ -361,11 +363,11 @@ func TestAcceptance_UploadFromTestDirectory(t *testing.T) {
 			t.Fatalf("Failed to open %s: %v", filePath, err)
 		}
 
-		input := &UploadInput{
+		input := &uploader.UploadInput{
 			Bucket:      suite.bucketName,
 			Key:         file.Name(),
 			Body:        f,
-			ContentType: stringPtr("application/octet-stream"),
+			ContentType: aws.String("application/octet-stream"),
 		}
 
 		_, err = suite.uploader.Upload(suite.ctx, input)
@@ -416,26 +418,15 @@ func TestAcceptance_FullUploadPipeline(t *testing.T) {
 		}
 	}
 
-	// Test the s3putGenWithUploader function
-	oldSource := opts.Source
-	oldBucket := opts.BucketName
-	opts.Source = tmpDir
-	opts.BucketName = suite.bucketName
-	defer func() {
-		opts.Source = oldSource
-		opts.BucketName = oldBucket
-	}()
-
-	// Create uploader function with our test uploader
-	uploadFn := s3putGenWithUploader(suite.uploader)
-
-	// Upload each file
-	for name := range testFiles {
-		src := newSourceFile(name)
-		err := uploadFn(src)
-		if err != nil {
-			t.Errorf("Failed to upload %s: %v", name, err)
-		}
+	// Sync the folder with the library, using our test uploader
+	store := uploader.NewLocalCacheStore(filepath.Join(t.TempDir(), "cache.txt"))
+	syncer := uploader.New(uploader.Options{Bucket: suite.bucketName, Source: tmpDir}, suite.uploader, nil, store)
+	res, err := syncer.Run(suite.ctx)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(res.Rejected) != 0 {
+		t.Errorf("Expected no rejected files, got %v", res.Rejected)
 	}
 
 	// Verify all files are in S3
@@ -501,11 +492,11 @@ func TestAcceptance_InitAWSClientWithEndpoint(t *testing.T) {
 	})
 
 	// Upload using the global uploader
-	input := &UploadInput{
+	input := &uploader.UploadInput{
 		Bucket:      bucketName,
 		Key:         "test.txt",
 		Body:        strings.NewReader("test content"),
-		ContentType: stringPtr("text/plain"),
+		ContentType: aws.String("text/plain"),
 	}
 
 	_, err = s3Uploader.Upload(ctx, input)
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// invalidationMaxWait is how long to wait for an invalidation to complete, with -cloudfront-wait.
+const invalidationMaxWait = 30 * time.Minute
+
+// invalidate invalidates the keys uploaded and deleted by the run in the CloudFront distribution,
+// if one is set, collapsed into wildcards above opts.CloudFrontMaxPaths. With opts.CloudFrontWait,
+// it waits for the invalidation to complete.
+func invalidate(ctx context.Context, res *uploader.Result) error {
+	if opts.CloudFrontDistribution == "" || len(res.Keys) == 0 {
+		return nil
+	}
+	if cdn == nil {
+		return fmt.Errorf("cloudfront client is not initialized")
+	}
+
+	paths := uploader.InvalidationPaths(res.Keys, opts.CloudFrontMaxPaths)
+	out, err := cdn.Invalidate(ctx, &uploader.InvalidateInput{DistributionID: opts.CloudFrontDistribution, Paths: paths})
+	if err != nil {
+		return err
+	}
+	logger.Info("Created invalidation", "distribution", opts.CloudFrontDistribution, "id", out.ID, "paths", len(paths))
+
+	if !opts.CloudFrontWait {
+		return nil
+	}
+	if err := cdn.Wait(ctx, opts.CloudFrontDistribution, out.ID, invalidationMaxWait); err != nil {
+		return err
+	}
+	logger.Info("Invalidation completed", "distribution", opts.CloudFrontDistribution, "id", out.ID)
+
+	return nil
+}
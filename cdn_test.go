@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestRunInvalidate(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.CloudFrontDistribution = "E123"
+	opts.CloudFrontWait = true
+
+	s3Uploader, cdn = uploader.NewMockS3Uploader(), uploader.NewMockCloudFrontInvalidator()
+	defer func() { s3Uploader, cdn = nil, nil }()
+	mock := cdn.(*uploader.MockCloudFrontInvalidator)
+
+	if code := run(); code != Success {
+		t.Fatal("Expected the run to succeed, got", code)
+	}
+	if len(mock.Invalidations) != 1 || len(mock.Waits) != 1 {
+		t.Fatalf("Expected an invalidation to be created and waited for, got %d and %d", len(mock.Invalidations), len(mock.Waits))
+	}
+	in := mock.Invalidations[0]
+	if in.DistributionID != "E123" || strings.Join(in.Paths, " ") != "/barbaz.txt /foobar.html" {
+		t.Errorf("Unexpected invalidation %+v", in)
+	}
+
+	// Nothing changed, so there is nothing to invalidate.
+	if code := run(); code != Success || len(mock.Invalidations) != 1 {
+		t.Error("Expected no invalidation when nothing changed, got", len(mock.Invalidations))
+	}
+}
+
+func TestRunInvalidateFailure(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.CloudFrontDistribution = "E123"
+
+	mock := uploader.NewMockCloudFrontInvalidator()
+	mock.Err = errors.New("TooManyInvalidationsInProgress")
+	s3Uploader, cdn = uploader.NewMockS3Uploader(), mock
+	defer func() { s3Uploader, cdn = nil, nil }()
+
+	if code := run(); code != InvalidationFailure {
+		t.Error("Expected the run to fail with InvalidationFailure, got", code)
+	}
+}
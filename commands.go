@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// Subcommands. Running without one (just flags) is the same as running "sync".
+const (
+	cmdSync            = "sync"
+	cmdPlan            = "plan"
+	cmdApply           = "apply"
+	cmdCacheRebuild    = "cache rebuild"
+	cmdCacheShow       = "cache show"
+	cmdRelease         = "release"
+	cmdReleaseList     = "release list"
+	cmdReleasePromote  = "release promote"
+	cmdReleaseRollback = "release rollback"
+	cmdRollback        = "rollback"
+	cmdHistory         = "history"
+	cmdHistoryShow     = "history show"
+	cmdMultipartList   = "multipart list"
+	cmdMultipartClean  = "multipart cleanup"
+	cmdConfigSave      = "config save"
+	cmdConfigShow      = "config show"
+	cmdVersion         = "version"
+)
+
+// command is a subcommand that runs against a target (see runTarget).
+type command struct {
+	run   func() int
+	usage string
+
+	// upload is set for the commands that write to the bucket, which need a fully
+	// validated config and an S3 client. The others only need the S3 client if the
+	// cache is kept in S3.
+	upload bool
+	// remote is set for the other commands that need an S3 client.
+	remote bool
+}
+
+// commands holds the subcommands that run against targets. version and the config ones
+// are handled upfront, before any target is selected.
+var commands = map[string]command{
+	cmdSync:         {run: run, upload: true, usage: "Upload the new and changed files and update the cache (default)"},
+	cmdPlan:         {run: runPlan, usage: "Show what sync would change, without changing anything; -plan saves it"},
+	cmdApply:        {run: runApply, upload: true, usage: "Carry out the plan saved with plan -plan"},
+	cmdCacheRebuild: {run: runCacheRebuild, usage: "Rebuild the cache from the source folder, without uploading anything"},
+	cmdCacheShow:    {run: runCacheShow, usage: "Print the content of the cache"},
+
+	cmdRelease:         {run: runRelease, upload: true, usage: "Upload everything as a new release [id], make it live and prune the old ones"},
+	cmdReleaseList:     {run: runReleaseList, remote: true, usage: "List the releases"},
+	cmdReleasePromote:  {run: runReleasePromote, remote: true, usage: "Make the given release <id> live"},
+	cmdReleaseRollback: {run: runReleaseRollback, remote: true, usage: "Make the release before the live one live again"},
+	cmdRollback:        {run: runRollback, remote: true, usage: "Restore the objects changed by the given run <id>, on versioned buckets"},
+	cmdHistory:         {run: runHistory, usage: "List the past runs, or those that changed the given [key]"},
+	cmdHistoryShow:     {run: runHistoryShow, usage: "Show the files changed by the given run <id>"},
+	cmdMultipartList:   {run: runMultipartList, remote: true, usage: "List the incomplete multipart uploads in the bucket"},
+	cmdMultipartClean:  {run: runMultipartCleanup, remote: true, usage: "Abort the incomplete multipart uploads older than -multipart-stale-after"},
+}
+
+// commandNames lists all the subcommands in the order they are shown in the usage.
+var commandNames = []string{cmdSync, cmdPlan, cmdApply, cmdCacheRebuild, cmdCacheShow, cmdRelease, cmdReleaseList,
+	cmdReleasePromote, cmdReleaseRollback, cmdRollback, cmdHistory, cmdHistoryShow,
+	cmdMultipartList, cmdMultipartClean, cmdConfigSave, cmdConfigShow, cmdVersion}
+
+// commandUsage describes the subcommands that do not run against targets.
+var commandUsage = map[string]string{
+	cmdConfigSave: "Save the current options to the config file",
+	cmdConfigShow: "Print the effective configuration and where each value came from",
+	cmdVersion:    "Print version information",
+}
+
+// parseCommand splits the command line arguments in the subcommand and its flags.
+// Arguments that start with a flag select the default "sync" command. An argument right
+// after the subcommand (e.g. a release ID) is moved after the flags, where flag parsing
+// leaves it (see options.args).
+func parseCommand(args []string) (cmd string, rest []string, err error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return cmdSync, args, nil
+	}
+
+	switch args[0] {
+	case cmdSync, cmdPlan, cmdApply, cmdVersion:
+		return args[0], args[1:], nil
+	case cmdRollback:
+		return cmdRollback, argsLast(args[1:]), nil
+	case cmdRelease, cmdHistory:
+		if len(args) > 1 {
+			if _, ok := commands[args[0]+" "+args[1]]; ok {
+				return args[0] + " " + args[1], argsLast(args[2:]), nil
+			}
+		}
+		return args[0], argsLast(args[1:]), nil
+	case "cache", "config", "multipart":
+		if len(args) > 1 {
+			cmd = args[0] + " " + args[1]
+			if _, ok := commands[cmd]; ok || commandUsage[cmd] != "" {
+				return cmd, args[2:], nil
+			}
+		}
+	}
+
+	return "", nil, fmt.Errorf("unknown command %q", strings.Join(args[:min(2, len(args))], " "))
+}
+
+// argsLast moves the leading argument of the subcommand, if any, after its flags.
+func argsLast(args []string) []string {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return args
+	}
+
+	return append(args[1:len(args):len(args)], args[0])
+}
+
+// usage prints the list of subcommands, followed by the flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range commandNames {
+		desc := commandUsage[name]
+		if cmd, ok := commands[name]; ok {
+			desc = cmd.usage
+		}
+		fmt.Fprintf(out, "  %-17s %s\n", name, desc)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runPlan prints the changes sync would make, and saves them to the plan file if one is given.
+func runPlan() int {
+	syncer, err := newSyncer()
+	if err != nil {
+		fmt.Println("Caching failed: ", err)
+		return CachingFailure
+	}
+
+	p, err := syncer.Plan(context.Background())
+	if err != nil {
+		fmt.Println("Caching failed: ", err)
+		return CachingFailure
+	}
+	if err = p.Measure(); err != nil {
+		fmt.Println("Planning failed: ", err)
+		return PlanFailure
+	}
+	p.Print(os.Stdout)
+
+	if opts.planFile != "" {
+		if err = p.Dump(opts.planFile); err != nil {
+			fmt.Println("Saving the plan failed: ", err)
+			return PlanFailure
+		}
+		fmt.Printf("\nSaved the plan to %s, run apply -plan=%s to carry it out.\n", opts.planFile, opts.planFile)
+	}
+
+	return Success
+}
+
+// runApply carries out the plan saved in the plan file.
+func runApply() int {
+	if opts.planFile == "" {
+		fmt.Println("Apply failed: no plan file given, use -plan")
+		return CmdLineOptionError
+	}
+
+	p, err := uploader.LoadPlan(opts.planFile)
+	if err != nil {
+		fmt.Println("Apply failed: ", err)
+		return PlanFailure
+	}
+
+	return runWithPlan(p)
+}
+
+// runCacheRebuild rebuilds the cache from the source folder, as if everything had been uploaded.
+// Nothing is synced, so unlike run, it runs no hooks, sends no notification and records no run.
+func runCacheRebuild() int {
+	opts.DoUpload, opts.DoCache, opts.DryRun = false, true, false
+	unlock, code := lockCache()
+	if code != Success {
+		return code
+	}
+	defer unlock()
+
+	syncer, err := newSyncer()
+	if err != nil {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
+	}
+	if _, err := syncer.Run(context.Background()); err != nil {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
+	}
+
+	return Success
+}
+
+// runCacheShow prints the content of the cache.
+func runCacheShow() int {
+	store, err := newCacheStore(opts, s3Uploader)
+	if err != nil {
+		fmt.Println("Caching failed: ", err)
+		return CachingFailure
+	}
+
+	fc, err := store.Load(context.Background())
+	if err != nil {
+		fmt.Println("Caching failed: ", err)
+		return CachingFailure
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tMODIFIED\tMD5\tETAG\tUPLOADED")
+	for _, name := range fc.Names() {
+		e := fc[name]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", e.Name, e.Size, formatTime(e.ModTime), e.MD5, e.ETag, formatTime(e.UploadedAt))
+	}
+	if err = tw.Flush(); err != nil {
+		fmt.Println(err)
+		return CachingFailure
+	}
+
+	return Success
+}
+
+// formatTime formats t for display, zero times are shown as "-".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestParseCommand(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		cmd      string
+		rest     string
+		hasError bool
+	}{
+		{nil, cmdSync, "", false},
+		{[]string{"-bucket=foo", "-dry"}, cmdSync, "-bucket=foo -dry", false},
+		{[]string{"sync", "-bucket=foo"}, cmdSync, "-bucket=foo", false},
+		{[]string{"plan"}, cmdPlan, "", false},
+		{[]string{"cache", "rebuild", "-source=out"}, cmdCacheRebuild, "-source=out", false},
+		{[]string{"cache", "show"}, cmdCacheShow, "", false},
+		{[]string{"config", "save", "-bucket=foo"}, cmdConfigSave, "-bucket=foo", false},
+		{[]string{"config", "show"}, cmdConfigShow, "", false},
+		{[]string{"version"}, cmdVersion, "", false},
+		{[]string{"release", "-bucket=foo"}, cmdRelease, "-bucket=foo", false},
+		{[]string{"release", "v1", "-bucket=foo"}, cmdRelease, "-bucket=foo v1", false},
+		{[]string{"release", "list"}, cmdReleaseList, "", false},
+		{[]string{"release", "promote", "v1", "-bucket=foo"}, cmdReleasePromote, "-bucket=foo v1", false},
+		{[]string{"rollback", "20260101T000000.000Z", "-bucket=foo"}, cmdRollback, "-bucket=foo 20260101T000000.000Z", false},
+		{[]string{"history"}, cmdHistory, "", false},
+		{[]string{"history", "index.html", "-target=prod"}, cmdHistory, "-target=prod index.html", false},
+		{[]string{"history", "show", "20260101T000000.000Z"}, cmdHistoryShow, "20260101T000000.000Z", false},
+		{[]string{"multipart", "cleanup", "-dry"}, cmdMultipartClean, "-dry", false},
+		{[]string{"multipart"}, "", "", true},
+		{[]string{"cache"}, "", "", true},
+		{[]string{"cache", "drop"}, "", "", true},
+		{[]string{"upload"}, "", "", true},
+	}
+
+	for _, tc := range testCases {
+		cmd, rest, err := parseCommand(tc.args)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("Expected %v to fail", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", tc.args, err)
+			continue
+		}
+		if cmd != tc.cmd || strings.Join(rest, " ") != tc.rest {
+			t.Errorf("Expected %v to give %q %q, got %q %v", tc.args, tc.cmd, tc.rest, cmd, rest)
+		}
+	}
+}
+
+func TestRunCacheRebuild(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.Quiet = true
+	opts.Hooks = &hookCommands{PreSync: "exit 1", PostSync: "exit 1"}
+	opts.NotifyURL = "http://127.0.0.1:0/unreachable"
+
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	if code := runCacheRebuild(); code != Success {
+		t.Fatal("Expected the rebuild to succeed, without running the hooks, got", code)
+	}
+	if len(mock.Uploads) != 0 {
+		t.Error("Expected nothing to be uploaded, got", len(mock.Uploads))
+	}
+	if _, err := os.Stat(historyFile(opts.CacheFile)); !os.IsNotExist(err) {
+		t.Error("Expected the rebuild not to be recorded in the history")
+	}
+
+	fc := uploader.FileCache{}
+	if err := fc.Load(opts.CacheFile); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if fc["barbaz.txt"] == nil || fc["foobar.html"] == nil {
+		t.Error("Expected the cache to hold all the source files, got", fc.Names())
+	}
+
+	// Once rebuilt, there is nothing left to upload.
+	syncer, err := newSyncer()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	p, err := syncer.Plan(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !p.Empty() {
+		t.Error("Expected no differences after a rebuild, got", p.Summary)
+	}
+}
+
+func TestRunWithPlan(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.planFile = filepath.Join(t.TempDir(), "plan.json")
+	opts.Quiet = true
+
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	if code := runPlan(); code != Success {
+		t.Fatal("Expected plan to succeed, got", code)
+	}
+	if len(mock.Uploads) != 0 {
+		t.Fatal("Expected plan not to upload anything")
+	}
+
+	if code := runApply(); code != Success {
+		t.Fatal("Expected apply to succeed, got", code)
+	}
+	if len(mock.Uploads) != 2 {
+		t.Fatal("Expected the planned uploads, got", len(mock.Uploads))
+	}
+
+	// Everything is uploaded now, so the plan is out of date.
+	if code := runApply(); code != PlanFailure {
+		t.Error("Expected applying the plan again to fail, got", code)
+	}
+}
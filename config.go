@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// envPrefix is the prefix of the environment variables that can be used instead of the flags.
+const envPrefix = "GO_S3_UPLOADER_"
+
+// Where a setting came from, from lowest to highest precedence.
+const (
+	sourceDefault = "default"
+	sourceConfig  = "config file"
+	sourceTarget  = "target"
+	sourceEnv     = "environment"
+	sourceFlag    = "command line"
+)
+
+// flagConfigKeys maps the flag names to the config file keys of the settings they control.
+// Flags not listed here (-cfgfile, -plan, -save, -print-config, -version) are never persisted.
+var flagConfigKeys = map[string]string{
+	"bucket":       "bucket_name",
+	"source":       "source",
+	"cachefile":    "cache_file",
+	"cache-remote": "cache_remote",
+	"region":       "region",
+	"profile":      "profile",
+	"target":       "target",
+	"log-level":    "log_level",
+	"log-format":   "log_format",
+	"log-file":     "log_file",
+	"metrics-addr": "metrics_addr",
+	"metrics-file": "metrics_file",
+	"metrics-push": "metrics_push",
+	"trace-otlp":   "trace_otlp",
+	"trace-file":   "trace_file",
+
+	"cloudfront-distribution": "cloudfront_distribution",
+	"cloudfront-max-paths":    "cloudfront_max_paths",
+	"cloudfront-wait":         "cloudfront_wait",
+	"notify-url":              "notify_url",
+	"notify-format":           "notify_format",
+	"release-prefix":          "release_prefix",
+	"release-pointers":        "release_pointers",
+	"release-keep":            "release_keep",
+	"run-manifests":           "run_manifests",
+	"workers":                 "workers_count",
+	"lock-timeout":            "lock_timeout",
+	"part-size":               "part_size",
+	"part-concurrency":        "part_concurrency",
+	"leave-parts-on-error":    "leave_parts_on_error",
+	"resume":                  "resume",
+	"multipart-stale-after":   "multipart_stale_after",
+	"encrypt":                 "encrypt",
+	"paranoid":                "paranoid",
+	"copy-headers":            "copy_headers",
+	"delete":                  "delete",
+	"dry":                     "dry_run",
+	"verbose":                 "verbose",
+	"quiet":                   "quiet",
+	"cache":                   "cache",
+	"upload":                  "upload",
+}
+
+// configSources maps flag names to where their current value came from.
+type configSources map[string]string
+
+// envName returns the name of the environment variable corresponding to a flag,
+// e.g. GO_S3_UPLOADER_CACHE_REMOTE for -cache-remote.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig defines the flags on fs and fills in opts from, in increasing order of precedence:
+// the defaults (opts as given), the config file, the environment variables and the command line
+// flags (args). The config file is the one given on the command line or in the environment, if any.
+//
+// Each layer overrides the previous ones for every setting it explicitly sets, including to
+// false or zero values: the config file settings present in the file, the environment variables
+// that are defined and the flags visited by fs.Parse.
+func loadConfig(fs *flag.FlagSet, opts *options, args []string, lookupEnv func(string) (string, bool)) (configSources, error) {
+	defineFlags(fs, opts)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	cfgFile := opts.cfgFile
+	if _, ok := explicit["cfgfile"]; !ok {
+		if v, ok := lookupEnv(envName("cfgfile")); ok {
+			cfgFile = v
+		}
+	}
+
+	if err := opts.restore(cfgFile); err != nil {
+		return nil, err
+	}
+
+	sources := configSources{}
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = sourceDefault
+		if key, ok := flagConfigKeys[f.Name]; ok && opts.set[key] {
+			sources[f.Name] = sourceConfig
+		}
+	})
+
+	var err error
+	opts.overrides = map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := lookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if err = f.Value.Set(v); err != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", v, envName(f.Name), err)
+			return
+		}
+		sources[f.Name] = sourceEnv
+		opts.overrides[f.Name] = v
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, v := range explicit {
+		if err := fs.Set(name, v); err != nil {
+			return nil, err
+		}
+		sources[name] = sourceFlag
+		opts.overrides[name] = v
+	}
+
+	return sources, nil
+}
+
+// showConfig prints the effective configuration (see printConfig), that of the target if a single
+// one is selected with -target, as layered by forTarget. Its settings are shown as coming from the
+// target, unless set in the environment or on the command line.
+func showConfig(w io.Writer, fs *flag.FlagSet, opts *options, sources configSources) error {
+	names, err := opts.targetNames()
+	if err != nil || len(names) != 1 {
+		return printConfig(w, fs, opts, sources)
+	}
+
+	t, err := opts.forTarget(names[0])
+	if err != nil {
+		return err
+	}
+	tfs := flag.NewFlagSet(names[0], flag.ContinueOnError)
+	defineFlags(tfs, t)
+
+	tsources := configSources{}
+	tfs.VisitAll(func(f *flag.Flag) {
+		tsources[f.Name] = sources[f.Name]
+		if src := sources[f.Name]; src == sourceEnv || src == sourceFlag {
+			return
+		}
+		if key, ok := flagConfigKeys[f.Name]; ok && opts.Targets[names[0]].set[key] || f.Value.String() != fs.Lookup(f.Name).Value.String() {
+			tsources[f.Name] = sourceTarget + " " + names[0]
+		}
+	})
+
+	return printConfig(w, tfs, t, tsources)
+}
+
+// printConfig writes the effective configuration to w: every flag, with its value
+// and where that came from, followed by the config file only settings.
+func printConfig(w io.Writer, fs *flag.FlagSet, opts *options, sources configSources) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tSOURCE\tENVIRONMENT VARIABLE")
+	fs.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(tw, "-%s\t%s\t%s\t%s\n", f.Name, f.Value.String(), sources[f.Name], envName(f.Name))
+	})
+
+	if len(opts.Headers) > 0 {
+		fmt.Fprintf(tw, "headers\t%d rules\t%s\t\n", len(opts.Headers), sourceConfig)
+	}
+	if len(opts.Phases) > 0 {
+		fmt.Fprintf(tw, "phases\t%s\t%s\t\n", strings.Join(opts.Phases, " "), sourceConfig)
+	}
+	if names := opts.Hooks.names(); len(names) > 0 {
+		fmt.Fprintf(tw, "hooks\t%s\t%s\t\n", strings.Join(names, ","), sourceConfig)
+	}
+	if len(opts.Targets) > 0 {
+		names := make([]string, 0, len(opts.Targets))
+		for name := range opts.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(tw, "targets\t%s\t%s\t\n", strings.Join(names, ","), sourceConfig)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported config file formats.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormatOf returns the config format for a file name, based on its extension.
+// Anything other than .yaml, .yml or .toml is treated as JSON.
+func configFormatOf(fname string) string {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	default:
+		return formatJSON
+	}
+}
+
+// encodeConfig serializes the options in the given format. The targets only get the settings
+// they set (see options.isSet), so that they keep inheriting the others once loaded back.
+func encodeConfig(format string, o *options) ([]byte, error) {
+	doc := encodable(o, func(string, reflect.Value) bool { return true })
+	switch format {
+	case formatYAML:
+		return yaml.Marshal(doc)
+	case formatTOML:
+		return toml.Marshal(doc)
+	default:
+		buf, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return append(buf, '\n'), nil
+	}
+}
+
+// encodable returns a copy of o to encode: a struct with the same persisted fields, and tags, but
+// only those keep accepts, and with the targets as encodable copies of their own, holding only the
+// settings they set.
+func encodable(o *options, keep func(key string, v reflect.Value) bool) any {
+	src := reflect.ValueOf(o).Elem()
+	var fields []reflect.StructField
+	var values []reflect.Value
+	for _, f := range reflect.VisibleFields(src.Type()) {
+		key := configKey(f)
+		v := src.FieldByIndex(f.Index)
+		if key == "" || !keep(key, v) {
+			continue
+		}
+
+		if key == "targets" {
+			targets := make(map[string]any, len(o.Targets))
+			for name, t := range o.Targets {
+				targets[name] = encodable(t, t.isSet)
+			}
+			v = reflect.ValueOf(targets)
+		}
+		fields = append(fields, reflect.StructField{Name: f.Name, Type: v.Type(), Tag: f.Tag})
+		values = append(values, v)
+	}
+
+	out := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, v := range values {
+		out.Field(i).Set(v)
+	}
+
+	return out.Interface()
+}
+
+// decodeConfig strictly decodes the options from buf, in the given format: unknown fields
+// are errors. Errors mention the line they refer to, whenever that is known.
+//
+// It also records which settings were present in buf (see options.merge), for the options
+// and for each of its targets.
+func decodeConfig(format string, buf []byte, o *options) (err error) {
+	raw := map[string]any{}
+	switch format {
+	case formatYAML:
+		if err = decodeYAML(buf, o); err == nil {
+			err = yaml.Unmarshal(buf, &raw)
+		}
+	case formatTOML:
+		if err = decodeTOML(buf, o); err == nil {
+			err = toml.Unmarshal(buf, &raw)
+		}
+	default:
+		if err = decodeJSON(buf, o); err == nil {
+			err = json.Unmarshal(buf, &raw)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	o.set = keysOf(raw)
+	targets, _ := raw["targets"].(map[string]any)
+	for name, t := range o.Targets {
+		if t == nil {
+			t = &options{}
+			o.Targets[name] = t
+		}
+		rawTarget, _ := targets[name].(map[string]any)
+		t.set = keysOf(rawTarget)
+	}
+
+	return nil
+}
+
+// keysOf returns the set of keys of a decoded config.
+func keysOf(raw map[string]any) map[string]bool {
+	keys := make(map[string]bool, len(raw))
+	for k := range raw {
+		keys[k] = true
+	}
+
+	return keys
+}
+
+func decodeJSON(buf []byte, o *options) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(o)
+	if err == nil {
+		return nil
+	}
+
+	offset := dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	} else if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		// The decoder does not tell where the unknown field is, look for its first occurrence as a key.
+		if name, err2 := strconv.Unquote(field); err2 == nil {
+			re := regexp.MustCompile(`"` + regexp.QuoteMeta(name) + `"\s*:`)
+			if loc := re.FindIndex(buf); loc != nil {
+				offset = int64(loc[0])
+			}
+		}
+	}
+
+	return fmt.Errorf("line %d: %w", lineOf(buf, offset), err)
+}
+
+func decodeYAML(buf []byte, o *options) error {
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+
+	// yaml errors already mention the line(s) they refer to.
+	if err := dec.Decode(o); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func decodeTOML(buf []byte, o *options) error {
+	dec := toml.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(o)
+	if err == nil {
+		return nil
+	}
+
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) && len(strictErr.Errors) > 0 {
+		line, _ := strictErr.Errors[0].Position()
+		return fmt.Errorf("line %d: toml: unknown field %q", line, strings.Join(strictErr.Errors[0].Key(), "."))
+	}
+
+	var decErr *toml.DecodeError
+	if errors.As(err, &decErr) {
+		line, _ := decErr.Position()
+		return fmt.Errorf("line %d: %w", line, err)
+	}
+
+	return err
+}
+
+// lineOf returns the (1 based) line number of the given byte offset in buf.
+func lineOf(buf []byte, offset int64) int {
+	if offset > int64(len(buf)) {
+		offset = int64(len(buf))
+	}
+
+	return bytes.Count(buf[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestConfigFormatOf(t *testing.T) {
+	tests := map[string]string{
+		".go-s3-uploader.json": formatJSON,
+		"cfg.yaml":             formatYAML,
+		"cfg.YML":              formatYAML,
+		"cfg.toml":             formatTOML,
+		"cfg":                  formatJSON,
+	}
+
+	for fname, expected := range tests {
+		if actual := configFormatOf(fname); actual != expected {
+			t.Errorf("Expected %s to be %s, got %s", fname, expected, actual)
+		}
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	o1 := &options{
+		BucketName:   "bucket",
+		Source:       "output",
+		WorkersCount: 3,
+		LockTimeout:  duration(time.Minute),
+		DryRun:       true,
+		Verbose:      true,
+		DoUpload:     true,
+		Headers:      []headerRule{{Pattern: "\\.html$", Headers: uploader.Headers{uploader.CacheControl: "max-age=60"}}},
+		Targets:      map[string]*options{"prod": {BucketName: "prod-bucket"}},
+	}
+
+	for _, ext := range []string{".json", ".yaml", ".toml"} {
+		fname := filepath.Join(t.TempDir(), "cfg"+ext)
+		if err := o1.dump(fname); err != nil {
+			t.Fatalf("%s: failed to dump config: %v", ext, err)
+		}
+
+		o2 := &options{}
+		if err := o2.restore(fname); err != nil {
+			t.Fatalf("%s: failed to restore config: %v", ext, err)
+		}
+
+		if o2.BucketName != "bucket" || o2.WorkersCount != 3 || o2.LockTimeout != o1.LockTimeout ||
+			!o2.DryRun || !o2.Verbose || !o2.DoUpload || o2.Quiet {
+			t.Errorf("%s: options do not match after a round trip: %+v", ext, o2)
+		}
+		if len(o2.Headers) != 1 || o2.Headers[0].Headers[uploader.CacheControl] != "max-age=60" {
+			t.Errorf("%s: header rules do not match after a round trip: %+v", ext, o2.Headers)
+		}
+		if o2.Targets["prod"] == nil || o2.Targets["prod"].BucketName != "prod-bucket" {
+			t.Errorf("%s: targets do not match after a round trip: %+v", ext, o2.Targets)
+		}
+	}
+}
+
+func TestConfigStrictDecoding(t *testing.T) {
+	tests := []struct{ name, content, line string }{
+		{"cfg.json", "{\n  \"bucket_name\": \"b\",\n  \"bucket\": \"typo\"\n}\n", ".json: line 3:"},
+		{"cfg.yaml", "bucket_name: b\nbucket: typo\n", "line 2"},
+		{"cfg.toml", "bucket_name = \"b\"\nbucket = \"typo\"\n", ".toml: line 2:"},
+	}
+
+	for _, tc := range tests {
+		fname := filepath.Join(t.TempDir(), tc.name)
+		if err := os.WriteFile(fname, []byte(tc.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := (&options{}).restore(fname)
+		if err == nil {
+			t.Errorf("%s: expected unknown fields to be rejected", tc.name)
+			continue
+		}
+		if msg := err.Error(); !strings.Contains(msg, "bucket") || !strings.Contains(msg, tc.line) {
+			t.Errorf("%s: expected the error to point at %s, got %q", tc.name, tc.line, msg)
+		}
+	}
+}
+
+func TestConfigDecodingTypeErrorLine(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cfg.json")
+	content := "{\n  \"bucket_name\": \"b\",\n  \"workers_count\": \"many\"\n}\n"
+	if err := os.WriteFile(fname, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := (&options{}).restore(fname)
+	if err == nil || !strings.HasPrefix(err.Error(), fname+": line 3:") {
+		t.Errorf("Expected a type error on line 3, got %v", err)
+	}
+}
+
+func TestConfigSaveKeepsTargetsInheriting(t *testing.T) {
+	for _, ext := range []string{".json", ".yaml", ".toml"} {
+		fname := filepath.Join(t.TempDir(), "cfg"+ext)
+		if err := os.WriteFile(filepath.Join(filepath.Dir(fname), "in.json"),
+			[]byte(`{"bucket_name": "b", "targets": {"prod": {"bucket_name": "prodb", "upload": false}}}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		saved := &options{DoUpload: true, DoCache: true, WorkersCount: 8}
+		if err := saved.restore(filepath.Join(filepath.Dir(fname), "in.json")); err != nil {
+			t.Fatal(err)
+		}
+		if err := saved.dump(fname); err != nil {
+			t.Fatalf("%s: failed to dump config: %v", ext, err)
+		}
+
+		o := &options{DoUpload: true, DoCache: true, WorkersCount: 8}
+		if err := o.restore(fname); err != nil {
+			t.Fatalf("%s: failed to restore config: %v", ext, err)
+		}
+		prod, err := o.forTarget("prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prod.BucketName != "prodb" || prod.DoUpload || !prod.DoCache || prod.WorkersCount != 8 {
+			t.Errorf("%s: expected the target to only set its own settings, got %+v", ext, prod)
+		}
+	}
+}
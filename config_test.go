@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fakeEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"bucket":       "GO_S3_UPLOADER_BUCKET",
+		"cache-remote": "GO_S3_UPLOADER_CACHE_REMOTE",
+		"lock-timeout": "GO_S3_UPLOADER_LOCK_TIMEOUT",
+	}
+
+	for flagName, expected := range tests {
+		if actual := envName(flagName); actual != expected {
+			t.Errorf("Expected %s got %s", expected, actual)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "cfg.json")
+	cfg := `{"bucket_name": "cfg-bucket", "source": "cfg-source", "region": "cfg-region", "workers_count": 3}`
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &options{Source: "output", Region: "default-region", Profile: "default-profile", cfgFile: cfgFile, DoUpload: true}
+	env := fakeEnv(map[string]string{
+		"GO_S3_UPLOADER_SOURCE": "env-source",
+		"GO_S3_UPLOADER_REGION": "env-region",
+		"GO_S3_UPLOADER_UPLOAD": "false",
+	})
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	sources, err := loadConfig(fs, o, []string{"-region=flag-region"}, env)
+	if err != nil {
+		t.Fatal("Failed to load config", err)
+	}
+
+	expected := []struct{ flag, value, actual, source string }{
+		{"profile", "default-profile", o.Profile, sourceDefault},
+		{"bucket", "cfg-bucket", o.BucketName, sourceConfig},
+		{"source", "env-source", o.Source, sourceEnv},
+		{"region", "flag-region", o.Region, sourceFlag},
+	}
+	for _, e := range expected {
+		if e.actual != e.value || sources[e.flag] != e.source {
+			t.Errorf("Expected -%s to be %q from %s, got %q from %s", e.flag, e.value, e.source, e.actual, sources[e.flag])
+		}
+	}
+
+	if o.WorkersCount != 3 || sources["workers"] != sourceConfig {
+		t.Error("Expected -workers to come from the config file, got", o.WorkersCount, sources["workers"])
+	}
+	if o.DoUpload || sources["upload"] != sourceEnv {
+		t.Error("Expected -upload to be turned off by the environment, got", o.DoUpload, sources["upload"])
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "cfg.yaml")
+	if err := os.WriteFile(cfgFile, []byte("bucket_name: yaml-bucket\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &options{cfgFile: "bogus.json"}
+	env := fakeEnv(map[string]string{"GO_S3_UPLOADER_CFGFILE": cfgFile})
+	if _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), o, nil, env); err != nil {
+		t.Fatal("Failed to load config", err)
+	}
+
+	if o.BucketName != "yaml-bucket" {
+		t.Error("Expected the config file given in the environment to be used, got", o.BucketName)
+	}
+}
+
+func TestLoadConfigInvalidEnv(t *testing.T) {
+	o := &options{cfgFile: "bogus.json"}
+	env := fakeEnv(map[string]string{"GO_S3_UPLOADER_WORKERS": "many"})
+	_, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), o, nil, env)
+	if err == nil || !strings.Contains(err.Error(), "GO_S3_UPLOADER_WORKERS") {
+		t.Error("Expected an invalid environment value to be reported, got", err)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	o := &options{cfgFile: "bogus.json", Targets: map[string]*options{"prod": {}, "staging": {}}}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sources, err := loadConfig(fs, o, []string{"-bucket=foo"}, fakeEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err = printConfig(out, fs, o, sources); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "-bucket" {
+			found = strings.Contains(line, "foo") && strings.Contains(line, sourceFlag) &&
+				strings.Contains(line, "GO_S3_UPLOADER_BUCKET")
+		}
+	}
+	if !found {
+		t.Error("Expected the bucket to be listed with its value and source, got\n", out.String())
+	}
+	if !strings.Contains(out.String(), "prod,staging") {
+		t.Error("Expected the targets to be listed, got\n", out.String())
+	}
+}
+
+func TestLoadConfigExplicitZeroValues(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "cfg.json")
+	cfg := `{"encrypt": true, "upload": false, "workers_count": 0, "verbose": true,
+		"targets": {"prod": {"encrypt": false}, "staging": {"bucket_name": "staging"}}}`
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                             string
+		args                             []string
+		env                              map[string]string
+		encrypt, upload, verbose         bool
+		workers                          int
+		encryptSrc, uploadSrc, workerSrc string
+	}{
+		{"config over defaults", nil, nil, true, false, true, 0, sourceConfig, sourceConfig, sourceConfig},
+		{"flags over config", []string{"-encrypt=false", "-upload=true", "-workers=5"}, nil,
+			false, true, true, 5, sourceFlag, sourceFlag, sourceFlag},
+		{"env over config", nil, map[string]string{"GO_S3_UPLOADER_ENCRYPT": "false", "GO_S3_UPLOADER_VERBOSE": "0"},
+			false, false, false, 0, sourceEnv, sourceConfig, sourceConfig},
+		{"flags over env", []string{"-encrypt"}, map[string]string{"GO_S3_UPLOADER_ENCRYPT": "false"},
+			true, false, true, 0, sourceFlag, sourceConfig, sourceConfig},
+	}
+
+	for _, tc := range tests {
+		o := &options{WorkersCount: 8, DoUpload: true, cfgFile: cfgFile}
+		sources, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), o, tc.args, fakeEnv(tc.env))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if o.Encrypt != tc.encrypt || o.DoUpload != tc.upload || o.Verbose != tc.verbose || o.WorkersCount != tc.workers {
+			t.Errorf("%s: unexpected options %+v", tc.name, o)
+		}
+		if sources["encrypt"] != tc.encryptSrc || sources["upload"] != tc.uploadSrc || sources["workers"] != tc.workerSrc {
+			t.Errorf("%s: unexpected sources %v", tc.name, sources)
+		}
+	}
+}
+
+func TestTargetOverridesWithZeroValues(t *testing.T) {
+	o := restoreTestConfig(t, `{"encrypt": true, "workers_count": 4,
+		"targets": {"prod": {"encrypt": false, "workers_count": 0}, "staging": {"bucket_name": "staging"}}}`)
+
+	prod, err := o.forTarget("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.Encrypt || prod.WorkersCount != 0 {
+		t.Errorf("Expected the target to turn encryption off and reset the workers, got %+v", prod)
+	}
+	if prod.workers() < 2 {
+		t.Error("Expected 0 workers to mean twice the number of CPUs, got", prod.workers())
+	}
+
+	staging, err := o.forTarget("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !staging.Encrypt || staging.WorkersCount != 4 {
+		t.Errorf("Expected the target to inherit the base settings it does not set, got %+v", staging)
+	}
+}
+
+func TestSavedConfigKeepsFalseValues(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cfg.yaml")
+	if err := (&options{BucketName: "b", DoUpload: false, DoCache: true}).dump(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &options{DoUpload: true, DoCache: false}
+	if err := o.restore(fname); err != nil {
+		t.Fatal(err)
+	}
+	if o.DoUpload || !o.DoCache {
+		t.Errorf("Expected the saved settings to be restored, got %+v", o)
+	}
+}
+
+func TestFlagConfigKeys(t *testing.T) {
+	keys := map[string]bool{}
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(options{})) {
+		if key := configKey(f); key != "" {
+			keys[key] = true
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	defineFlags(fs, &options{})
+	meta := map[string]bool{"cfgfile": true, "plan": true, "save": true, "print-config": true, "version": true}
+	fs.VisitAll(func(f *flag.Flag) {
+		key, ok := flagConfigKeys[f.Name]
+		if !ok && !meta[f.Name] {
+			t.Errorf("Flag -%s has no config key", f.Name)
+		}
+		if ok && !keys[key] {
+			t.Errorf("Flag -%s maps to unknown config key %s", f.Name, key)
+		}
+	})
+}
+
+func TestTargetUnderEnvAndFlags(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "cfg.json")
+	cfg := `{"bucket_name": "b", "targets": {"prod": {"bucket_name": "prodb", "workers_count": 8, "region": "us-east-1", "encrypt": true}}}`
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &options{cfgFile: cfgFile}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sources, err := loadConfig(fs, o, []string{"-workers=1", "-bucket=flagb", "-target=prod"},
+		fakeEnv(map[string]string{"GO_S3_UPLOADER_REGION": "eu-west-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prod, err := o.forTarget("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.WorkersCount != 1 || prod.BucketName != "flagb" || prod.Region != "eu-west-1" || !prod.Encrypt {
+		t.Errorf("Expected the flags and environment over the target, and the target over the config, got %+v", prod)
+	}
+
+	out := &bytes.Buffer{}
+	if err := showConfig(out, fs, o, sources); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"-workers", "1", sourceFlag, "-encrypt", "true", sourceTarget + " prod"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the config shown, got\n%s", want, out.String())
+		}
+	}
+}
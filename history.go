@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// historyFile returns the run history file kept next to the given cache file, e.g.
+// .go-s3-uploader.history.jsonl for .go-s3-uploader.txt.
+func historyFile(cacheFile string) string {
+	return strings.TrimSuffix(cacheFile, filepath.Ext(cacheFile)) + ".history.jsonl"
+}
+
+// runHistory lists the past runs, newest first, or only those that changed the key given as the
+// command argument, if any.
+func runHistory() int {
+	h, err := uploader.ReadHistory(historyFile(opts.CacheFile))
+	if err != nil {
+		fmt.Println("Reading the history failed: ", err)
+		return CachingFailure
+	}
+	if len(opts.args) > 0 {
+		h = h.Changed(opts.args[0])
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tBUCKET\tCHANGED\tREJECTED\tDURATION\tVERSION")
+	for i := len(h) - 1; i >= 0; i-- {
+		m := h[i]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.1fs\t%s\n", m.ID, formatTime(m.CreatedAt), m.Bucket, len(m.Files),
+			len(m.Rejected), m.DurationSeconds, m.Version)
+	}
+	if err = tw.Flush(); err != nil {
+		fmt.Println(err)
+		return CachingFailure
+	}
+
+	return Success
+}
+
+// runHistoryShow prints the details of the run given as the command argument.
+func runHistoryShow() int {
+	if len(opts.args) == 0 {
+		fmt.Println("Reading the history failed: no run ID given")
+		return CmdLineOptionError
+	}
+
+	h, err := uploader.ReadHistory(historyFile(opts.CacheFile))
+	if err != nil {
+		fmt.Println("Reading the history failed: ", err)
+		return CachingFailure
+	}
+	m := h.Find(opts.args[0])
+	if m == nil {
+		fmt.Printf("Reading the history failed: no run %q\n", opts.args[0])
+		return CachingFailure
+	}
+
+	fmt.Printf("Run:      %s\nCreated:  %s\nBucket:   %s\n", m.ID, formatTime(m.CreatedAt), m.Bucket)
+	if m.Target != "" {
+		fmt.Printf("Target:   %s\n", m.Target)
+	}
+	fmt.Printf("Version:  %s\nDuration: %.1fs\n\n", m.Version, m.DurationSeconds)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tKEY\tETAG\tVERSION\tPREVIOUS VERSION")
+	for _, f := range m.Files {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", f.Action, f.Key, orDash(f.ETag), orDash(f.VersionID), orDash(f.PreviousVersionID))
+	}
+	for _, name := range m.Rejected {
+		fmt.Fprintf(tw, "rejected\t%s\t-\t-\t-\n", name)
+	}
+	if err = tw.Flush(); err != nil {
+		fmt.Println(err)
+		return CachingFailure
+	}
+
+	return Success
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
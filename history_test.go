@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestHistoryFile(t *testing.T) {
+	if got := historyFile(filepath.Join("site", ".go-s3-uploader.prod.txt")); got != filepath.Join("site", ".go-s3-uploader.prod.history.jsonl") {
+		t.Error("Unexpected history file", got)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+
+	opts.DryRun = true
+	if code := run(); code != Success {
+		t.Fatal("Expected the dry run to succeed, got", code)
+	}
+	opts.DryRun = false
+	for range 2 {
+		if code := run(); code != Success {
+			t.Fatal("Expected the run to succeed, got", code)
+		}
+	}
+
+	h, err := uploader.ReadHistory(historyFile(opts.CacheFile))
+	if err != nil || len(h) != 2 {
+		t.Fatalf("Expected both runs, but not the dry one, in the history, got %d %v", len(h), err)
+	}
+	if len(h[0].Files) != 2 || h[0].Bucket != opts.BucketName || h[0].Version != Version || len(h[1].Files) != 0 {
+		t.Errorf("Unexpected history %+v %+v", h[0], h[1])
+	}
+	if changed := h.Changed("barbaz.txt"); len(changed) != 1 || changed[0].ID != h[0].ID {
+		t.Error("Expected the first run to have changed barbaz.txt, got", changed)
+	}
+
+	opts.args = []string{"barbaz.txt"}
+	if code := runHistory(); code != Success {
+		t.Error("Expected the history to be listed, got", code)
+	}
+	opts.args = []string{h[0].ID}
+	if code := runHistoryShow(); code != Success {
+		t.Error("Expected the run to be shown, got", code)
+	}
+	opts.args = []string{"unknown"}
+	if code := runHistoryShow(); code != CachingFailure {
+		t.Error("Expected showing an unknown run to fail, got", code)
+	}
+}
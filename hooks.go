@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// Hook names, also their config keys.
+const (
+	hookPreSync    = "pre_sync"
+	hookPostUpload = "post_upload"
+	hookPostSync   = "post_sync"
+	hookOnFailure  = "on_failure"
+)
+
+// hookCommands holds the shell commands run around a sync, set in the config file only.
+type hookCommands struct {
+	// PreSync runs before the files are hashed, e.g. to build the site. If it fails, nothing is uploaded.
+	PreSync string `json:"pre_sync,omitempty" yaml:"pre_sync,omitempty" toml:"pre_sync,omitempty"`
+	// PostUpload runs once the files are uploaded and the cache updated, e.g. for a smoke test.
+	PostUpload string `json:"post_upload,omitempty" yaml:"post_upload,omitempty" toml:"post_upload,omitempty"`
+	// PostSync runs at the end of the sync, whatever its outcome.
+	PostSync string `json:"post_sync,omitempty" yaml:"post_sync,omitempty" toml:"post_sync,omitempty"`
+	// OnFailure runs at the end of a sync that failed.
+	OnFailure string `json:"on_failure,omitempty" yaml:"on_failure,omitempty" toml:"on_failure,omitempty"`
+}
+
+// command returns the command of the named hook, "" if not set.
+func (h *hookCommands) command(name string) string {
+	if h == nil {
+		return ""
+	}
+
+	return map[string]string{
+		hookPreSync:    h.PreSync,
+		hookPostUpload: h.PostUpload,
+		hookPostSync:   h.PostSync,
+		hookOnFailure:  h.OnFailure,
+	}[name]
+}
+
+// names returns the names of the hooks set, in the order they run.
+func (h *hookCommands) names() []string {
+	var names []string
+	for _, name := range []string{hookPreSync, hookPostUpload, hookPostSync, hookOnFailure} {
+		if h.command(name) != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// hookRun describes the run to the hooks.
+type hookRun struct {
+	// runID identifies the run, e.g. in the run history (see recordRun).
+	runID string
+	// res is the result of the sync, nil before it or if it failed.
+	res *uploader.Result
+	// code is the exit code of the run so far.
+	code int
+	// changed is the file listing the keys the sync changed, "" until it is written.
+	changed string
+}
+
+// writeChanged writes the keys changed by the sync to a temporary file, one per line, for the
+// hooks that follow. The file is removed by cleanup.
+func (r *hookRun) writeChanged() error {
+	if r.res == nil || r.changed != "" {
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "go-s3-uploader-changed-*.txt")
+	if err != nil {
+		return err
+	}
+	r.changed = f.Name()
+
+	for _, key := range r.res.Keys {
+		if _, err := fmt.Fprintln(f, key); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
+
+// cleanup removes the changed files list, if any.
+func (r *hookRun) cleanup() {
+	if r.changed != "" {
+		_ = os.Remove(r.changed)
+	}
+}
+
+// env returns the environment of the hooks: that of the program, plus the GO_S3_UPLOADER_
+// variables describing the run. None of them is named after a flag (see envName), for a hook
+// running go-s3-uploader not to inherit the settings of the run as overrides.
+func (r *hookRun) env(name string) []string {
+	env := append(os.Environ(),
+		"GO_S3_UPLOADER_HOOK="+name,
+		"GO_S3_UPLOADER_RUN_ID="+r.runID,
+		"GO_S3_UPLOADER_RUN_BUCKET="+opts.BucketName,
+		"GO_S3_UPLOADER_RUN_SOURCE="+opts.Source,
+		"GO_S3_UPLOADER_RUN_TARGET="+opts.Target,
+		"GO_S3_UPLOADER_DRY_RUN="+strconv.FormatBool(opts.DryRun),
+	)
+	if r.changed != "" {
+		env = append(env, "GO_S3_UPLOADER_CHANGED_FILES="+r.changed)
+	}
+	if name == hookPostSync || name == hookOnFailure {
+		env = append(env, "GO_S3_UPLOADER_EXIT_STATUS="+strconv.Itoa(r.code))
+	}
+
+	return env
+}
+
+// runHook runs the named hook through the shell, if it is set, with its output going to ours.
+func runHook(ctx context.Context, name string, r *hookRun) error {
+	command := opts.Hooks.command(name)
+	if command == "" {
+		return nil
+	}
+	if err := r.writeChanged(); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, command) // #nosec G204 - user given hook
+	cmd.Env = r.env(name)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	logger.Debug("Running hook", "hook", name, "command", command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q: %w", name, strings.TrimSpace(command), err)
+	}
+
+	return nil
+}
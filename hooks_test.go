@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The hooks use sh")
+	}
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hooks.txt")
+	opts.CacheFile = filepath.Join(dir, "cache.txt")
+	opts.Hooks = &hookCommands{
+		PreSync:    `echo "$GO_S3_UPLOADER_HOOK $GO_S3_UPLOADER_RUN_BUCKET" >> ` + out,
+		PostUpload: `echo "$GO_S3_UPLOADER_HOOK" >> ` + out + ` && cat "$GO_S3_UPLOADER_CHANGED_FILES" >> ` + out,
+		PostSync:   `echo "$GO_S3_UPLOADER_HOOK $GO_S3_UPLOADER_EXIT_STATUS" >> ` + out,
+		OnFailure:  `echo "$GO_S3_UPLOADER_HOOK" >> ` + out,
+	}
+
+	if code := run(); code != Success {
+		t.Fatal("Expected the run to succeed, got", code)
+	}
+	buf, err := os.ReadFile(out) // #nosec G304 - test file
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	expected := "pre_sync " + opts.BucketName + "\npost_upload\nbarbaz.txt\nfoobar.html\npost_sync 0\n"
+	if string(buf) != expected {
+		t.Errorf("Expected the hooks to run as %q, got %q", expected, buf)
+	}
+
+	// A failing pre_sync hook stops the run before anything is uploaded.
+	if err := os.Remove(out); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	opts.CacheFile = filepath.Join(dir, "cache2.txt")
+	opts.Hooks.PreSync = "exit 3"
+	uploads := mock.UploadCount
+	if code := run(); code != HookFailure {
+		t.Error("Expected the run to fail with HookFailure, got", code)
+	}
+	if mock.UploadCount != uploads {
+		t.Error("Expected nothing to be uploaded, got", mock.UploadCount-uploads)
+	}
+	if buf, _ := os.ReadFile(out); string(buf) != "post_sync 8\non_failure\n" { // #nosec G304 - test file
+		t.Errorf("Expected the post_sync and on_failure hooks to run, got %q", buf)
+	}
+}
+
+func TestHookEnvNotFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	defineFlags(fs, &options{})
+
+	r := &hookRun{changed: "changed.txt"}
+	for _, kv := range r.env(hookPostSync)[len(os.Environ()):] {
+		name, _, _ := strings.Cut(kv, "=")
+		fs.VisitAll(func(f *flag.Flag) {
+			if envName(f.Name) == name {
+				t.Errorf("Expected %s not to override the -%s flag of a nested run", name, f.Name)
+			}
+		})
+	}
+}
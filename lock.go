@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockPollInterval is how often we retry taking a busy lock.
+const lockPollInterval = 100 * time.Millisecond
+
+// errLocked is returned by tryLock when the lock is held by someone else.
+var errLocked = errors.New("locked by another process")
+
+// cacheLock is an advisory lock guarding a cache file against concurrent runs.
+// The lock file itself is left in place on release, removing it would race with other runs.
+type cacheLock struct {
+	f *os.File
+}
+
+// acquireCacheLock takes the lock associated with the cache file, waiting up to timeout
+// for other runs to release it.
+func acquireCacheLock(cacheFile string, timeout time.Duration) (*cacheLock, error) {
+	fname := cacheFile + ".lock"
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0o644) // #nosec G302 G304 - lock file next to the user given cache
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = tryLock(f)
+		if err == nil {
+			return &cacheLock{f: f}, nil
+		}
+		if !errors.Is(err, errLocked) || time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("unable to lock %s: %w", fname, err)
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release releases the lock.
+func (l *cacheLock) release() error {
+	err := unlock(l.f)
+	if err2 := l.f.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// File locking is not available on this platform, concurrent runs are not guarded against.
+func tryLock(_ *os.File) error {
+	return nil
+}
+
+func unlock(_ *os.File) error {
+	return nil
+}
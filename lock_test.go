@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheLock(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "cache.txt")
+
+	lock, err := acquireCacheLock(cacheFile, 0)
+	if err != nil {
+		t.Fatal("Expected to acquire a free lock, got", err)
+	}
+
+	start := time.Now()
+	if _, err = acquireCacheLock(cacheFile, 3*lockPollInterval); err == nil {
+		t.Fatal("Expected to fail acquiring a held lock")
+	}
+	if waited := time.Since(start); waited < 3*lockPollInterval {
+		t.Error("Expected to wait for the lock timeout, waited", waited)
+	}
+
+	if err = lock.release(); err != nil {
+		t.Fatal("Failed to release the lock", err)
+	}
+
+	lock, err = acquireCacheLock(cacheFile, 0)
+	if err != nil {
+		t.Fatal("Expected to acquire a released lock, got", err)
+	}
+	_ = lock.release()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// Log formats
+const (
+	logCompact  = "compact"
+	logProgress = "progress"
+	logText     = "text"
+	logJSON     = "json"
+)
+
+// logger is the global logger, set up by newLogger from the options.
+var logger *slog.Logger
+
+// newLogger returns the logger configured by o, writing to w in o.LogFormat (progress on a
+// terminal, compact otherwise, text if o.Verbose) at o.LogLevel (info, or warn if o.Quiet).
+// If o.LogFile is set, the records are appended to that file too, as JSON unless -log-format=text.
+//
+// The log file is left open for the lifetime of the program.
+func newLogger(o *options, w io.Writer) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if o.Quiet {
+		level = slog.LevelWarn
+	}
+	if o.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(o.LogLevel)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", o.LogLevel)
+		}
+	}
+
+	format := o.LogFormat
+	if format == "" {
+		format = logCompact
+		if isTerminal(w) {
+			format = logProgress
+		}
+		if o.Verbose {
+			format = logText
+		}
+	}
+
+	h, err := newLogHandler(format, w, level)
+	if err != nil {
+		return nil, err
+	}
+	if o.LogFile == "" {
+		return slog.New(h), nil
+	}
+
+	f, err := os.OpenFile(o.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) // #nosec G302 G304 - user given log file
+	if err != nil {
+		return nil, err
+	}
+	fileFormat := logJSON
+	if o.LogFormat == logText {
+		fileFormat = logText
+	}
+	fh, err := newLogHandler(fileFormat, f, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return slog.New(teeHandler{h, fh}), nil
+}
+
+// newLogHandler returns the handler for the given format, writing to w.
+func newLogHandler(format string, w io.Writer, level slog.Leveler) (slog.Handler, error) {
+	switch format {
+	case logCompact:
+		return newCompactHandler(w, level), nil
+	case logProgress:
+		return newProgressHandler(w, level), nil
+	case logText:
+		return slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}), nil
+	case logJSON:
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected one of: %s, %s, %s, %s", format, logCompact, logProgress, logText, logJSON)
+	}
+}
+
+// compactHandler prints the compact progress view, meant for interactive use: "Uploading ",
+// followed by a character for each file (. done, r retrying, F failed) and " done!" at the end.
+// The records not about a file are only printed if they are warnings or errors, except for
+// the "Nothing to upload" one. Runs for a target are prefixed with its name.
+type compactHandler struct {
+	level  slog.Leveler
+	prefix string // "<target>: ", from the target attribute
+	*compactState
+}
+
+// compactState is shared by a compactHandler and all the handlers derived from it.
+type compactState struct {
+	sync.Mutex
+	w       io.Writer
+	started bool // "Uploading " was printed
+}
+
+func newCompactHandler(w io.Writer, level slog.Leveler) *compactHandler {
+	return &compactHandler{level: level, compactState: &compactState{w: w}}
+}
+
+func (h *compactHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *compactHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := attrsOf(r)
+
+	h.Lock()
+	defer h.Unlock()
+
+	var out string
+	switch {
+	case attrs.str("key") != "":
+		if !h.started {
+			out, h.started = h.prefix+"Uploading ", true
+		}
+		out += progressChar(r.Level)
+	case r.Message == "All done":
+		if h.started {
+			out, h.started = " done!\n", false
+		}
+	case r.Message == "Nothing to upload":
+		out = h.prefix + "Nothing to upload.\n"
+	case r.Level >= slog.LevelWarn:
+		out = h.prefix + r.Message + attrs.errSuffix() + "\n"
+		if h.started {
+			out, h.started = "\n"+out, false
+		}
+	}
+
+	_, err := io.WriteString(h.w, out)
+	return err
+}
+
+// progressChar returns the character standing for a file record of the given level.
+func progressChar(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "F"
+	case level >= slog.LevelWarn:
+		return "r"
+	default:
+		return "."
+	}
+}
+
+func (h *compactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	for _, a := range attrs {
+		if a.Key == "target" {
+			h2.prefix = a.Value.String() + ": "
+		}
+	}
+
+	return &h2
+}
+
+func (h *compactHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
+
+// recordAttrs holds the attributes of a record, for the console handlers to pick from.
+type recordAttrs map[string]slog.Value
+
+func attrsOf(r slog.Record) recordAttrs {
+	attrs := recordAttrs{}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.Resolve()
+		return true
+	})
+
+	return attrs
+}
+
+// str returns the key attribute as a string, empty if missing.
+func (a recordAttrs) str(key string) string {
+	if v, ok := a[key]; ok {
+		return v.String()
+	}
+
+	return ""
+}
+
+// int returns the key attribute as an integer, 0 if missing or not an integer.
+func (a recordAttrs) int(key string) int64 {
+	switch v := a[key]; v.Kind() {
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return int64(v.Uint64()) // #nosec G115 - counts and sizes
+	default:
+		return 0
+	}
+}
+
+// errSuffix returns ": <error>" for records with an error attribute, nothing otherwise.
+func (a recordAttrs) errSuffix() string {
+	if err := a.str("error"); err != "" {
+		return ": " + err
+	}
+
+	return ""
+}
+
+// teeHandler sends the records to all of its handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+
+	return out
+}
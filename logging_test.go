@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := slog.New(newCompactHandler(buf, slog.LevelInfo)).With("target", "prod")
+
+	log.Info("Syncing", "bucket", "example_bucket")
+	log.Info("Uploaded", "key", "a.html")
+	log.Warn("Retrying", "key", "b.html", "error", errors.New("EOF"))
+	log.Error("Failed to upload", "key", "b.html", "error", errors.New("EOF"))
+	log.Info("All done")
+	log.Info("Nothing to upload")
+
+	if expected := "prod: Uploading .rF done!\nprod: Nothing to upload.\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	log.Info("Uploaded", "key", "a.html")
+	log.Error("Caching failed", "error", errors.New("disk full"))
+	if expected := "prod: Uploading .\nprod: Caching failed: disk full\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestCompactHandlerQuiet(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := slog.New(newCompactHandler(buf, slog.LevelWarn))
+
+	log.Info("Uploaded", "key", "a.html")
+	log.Info("All done")
+	if buf.Len() != 0 {
+		t.Error("Expected nothing to be printed, got", buf.String())
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "go-s3-uploader.log")
+	buf := &bytes.Buffer{}
+	log, err := newLogger(&options{Verbose: true, LogLevel: "debug", LogFile: logFile}, buf)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	log.Debug("Uploaded", "key", "a.html", "bytes", 42)
+	if !strings.Contains(buf.String(), "level=DEBUG msg=Uploaded key=a.html bytes=42") {
+		t.Error("Expected a text record, got", buf.String())
+	}
+
+	// The log file gets the same records, as JSON unless the text format was asked for explicitly.
+	out, err := os.ReadFile(logFile) // #nosec G304 - test file
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !strings.Contains(string(out), `"msg":"Uploaded","key":"a.html","bytes":42`) {
+		t.Error("Expected a JSON record in the log file, got", string(out))
+	}
+
+	if _, err := newLogger(&options{LogLevel: "loud"}, buf); err == nil {
+		t.Error("Expected an invalid log level to fail")
+	}
+	if _, err := newLogger(&options{LogFormat: "xml"}, buf); err == nil {
+		t.Error("Expected an invalid log format to fail")
+	}
+}
+
+func TestProgressHandler(t *testing.T) {
+	buf, now := &bytes.Buffer{}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := newProgressHandler(buf, slog.LevelInfo)
+	h.now = func() time.Time { return now }
+	log := slog.New(h)
+
+	log.Info("Syncing", "upload", 3, "update_headers", 0, "delete", 1, "bytes", 3000)
+	if !strings.Contains(buf.String(), "[                    ] 0/4 files, 0 B/2.9 KiB, 0 B/s, 0 retries, 0 failed, ETA ?") {
+		t.Errorf("Unexpected progress %q", buf.String())
+	}
+
+	now = now.Add(time.Second)
+	log.Info("Uploaded", "key", "a.html", "bytes", 1000)
+	log.Warn("Retrying", "key", "b.html", "error", errors.New("EOF"))
+	now = now.Add(time.Second)
+	log.Error("Failed to upload", "key", "b.html", "error", errors.New("EOF"))
+	if !strings.Contains(buf.String(), "Failed to upload b.html: EOF\n") {
+		t.Errorf("Expected the failure to be printed, got %q", buf.String())
+	}
+	if !strings.HasSuffix(buf.String(), "[==========          ] 2/4 files, 1000 B/2.9 KiB, 500 B/s, 1 retries, 1 failed, ETA 4s") {
+		t.Errorf("Unexpected progress %q", buf.String())
+	}
+
+	log.Info("All done")
+	if !strings.HasSuffix(buf.String(), "ETA 4s\n") {
+		t.Errorf("Expected the progress line to be ended, got %q", buf.String())
+	}
+}
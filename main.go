@@ -1,21 +1,13 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
-	"flag"
+	"errors"
 	"fmt"
-	"io"
-	"math"
-	"mime"
 	"os"
-	"path/filepath"
-	"sort"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/alexaandru/utils"
+	"github.com/petems/go-s3-uploader/pkg/uploader"
 )
 
 // Exit codes
@@ -25,203 +17,253 @@ const (
 	S3AuthError
 	CmdLineOptionError
 	CachingFailure
+	LockFailure
+	PlanFailure
+	InvalidationFailure
+	HookFailure
+	ReleaseFailure
+	RollbackFailure
+	MultipartFailure
+	UploadFailure
 )
 
-// max number of attempts to retry a failed upload.
-const maxTries = 10
-
 // test environment constant
 const testEnv = "test"
 
-// signature of an s3 uploader func
-type uploader func(*sourceFile) error
-
-// filesLists returns both the current files list as well as the difference from the old (cached) files list.
-func filesLists() (utils.FileHashes, []string) {
-	current := utils.FileHashesNew(opts.Source)
-	old := utils.FileHashes{}
-	old.Load(opts.CacheFile)
-	diff := current.Diff(old)
+func main() {
+	code := runMain()
+	if metrics != nil {
+		if err := metrics.finish(code, opts); err != nil {
+			logger.Error("Exporting metrics failed", "error", err)
+		}
+	}
+	if err := shutdownTracing(); err != nil {
+		logger.Error("Exporting traces failed", "error", err)
+	}
 
-	return current, diff
+	if code != Success {
+		os.Exit(code)
+	}
 }
 
-// upload fetches sourceFiles from uploads chan, attempts to upload them and enqueue the results to
-// completed list. On failure it attempts to retry, up to maxTries per source file.
-func upload(fn uploader, uploads chan *sourceFile, rejected *syncedList, wgUploads, wgWorkers *sync.WaitGroup) {
-	defer wgWorkers.Done()
+// runMain runs the command for all the selected targets, returning the exit code.
+func runMain() int {
+	cmd, ok := commands[opts.command]
+	if !ok {
+		cmd = commands[cmdSync]
+	}
+
+	names, err := opts.targetNames()
+	if err != nil {
+		fmt.Printf("Invalid target: %v.\n", err)
+		return CmdLineOptionError
+	}
 
-	for src := range uploads {
-		src := src
+	if len(names) == 0 {
+		return runTarget(opts, cmd)
+	}
 
-		if opts.dryRun {
-			say(fmt.Sprintf("Pretending to upload %s", src.fname), ".")
-			wgUploads.Done()
-			continue
+	// Fan out to all the selected targets, one after the other, even if some of them fail.
+	base, baseLogger, exitCode := opts, logger, Success
+	defer func() { opts, logger = base, baseLogger }()
+	for _, name := range names {
+		t, err := base.forTarget(name)
+		if err != nil {
+			fmt.Printf("Invalid target: %v.\n", err)
+			return CmdLineOptionError
 		}
 
-		err := fn(src)
-		if err == nil {
-			wgUploads.Done()
-			say(fmt.Sprintf("Uploaded %s", src.fname), ".")
-			continue
+		logger = baseLogger.With("target", name)
+		if code := runTarget(t, cmd); code != Success && exitCode == Success {
+			exitCode = code
 		}
+	}
+
+	return exitCode
+}
 
-		src.recordAttempt()
-		if !src.retriable() || !isRecoverable(err) {
-			rejected.add(src.fname)
-			say(fmt.Sprintf("Failed to upload %s: %v", src.fname, err), "F")
-			wgUploads.Done()
-			continue
+// runTarget makes o the current options, then sets up what cmd needs and runs it.
+func runTarget(o *options, cmd command) int {
+	opts = o
+	if cmd.upload {
+		if err := validateCmdLineFlags(opts); err != nil {
+			fmt.Printf("Required field missing: %v.\n\n", err)
+			usage()
+			return CmdLineOptionError
 		}
+	}
 
-		go func() {
-			say(fmt.Sprintf("Retrying %s", src.fname), "r")
-			wait := time.Duration(100.0*math.Pow(2, float64(src.attempts))) * time.Millisecond
-			if appEnv == testEnv {
-				wait = time.Nanosecond
-			}
-			<-time.After(wait)
-			uploads <- src
-		}()
+	if appEnv != testEnv && (cmd.upload || cmd.remote || opts.CacheRemote != "") {
+		initAWSClient()
 	}
+
+	return cmd.run()
 }
 
-// s3putGen generates an S3 upload function using the S3Uploader interface.
-// In test mode, it returns a no-op function.
-// In production, it uses the global s3Uploader to perform actual uploads.
-func s3putGen() uploader {
-	return s3putGenWithUploader(s3Uploader)
+// run computes the changes, uploads them and updates the cache, returning the exit code.
+func run() int {
+	return runWithPlan(nil)
 }
 
-// s3putGenWithUploader generates an S3 upload function using the provided uploader.
-// This allows for dependency injection in tests.
-func s3putGenWithUploader(u S3Uploader) uploader {
-	if appEnv == testEnv && u == nil {
-		return func(_ *sourceFile) error {
-			return nil
-		}
+// runWithPlan is run, carrying out the saved plan instead of the freshly computed one
+// (see uploader.Syncer.Apply). Once synced, it runs the post_sync hook, the on_failure one
+// if the run failed, then sends the notification.
+func runWithPlan(saved *uploader.Plan) int {
+	ctx := context.Background()
+	unlock, code := lockCache()
+	if code != Success {
+		notifyRun(ctx, nil, code)
+		return code
 	}
+	defer unlock()
 
-	if u == nil {
-		return func(_ *sourceFile) error {
-			return fmt.Errorf("s3 uploader is not initialized")
-		}
-	}
+	r := &hookRun{runID: time.Now().UTC().Format(runIDFormat)}
+	defer r.cleanup()
+	r.code = syncWithPlan(ctx, saved, r)
 
-	return func(src *sourceFile) (err error) {
-		ctx := context.Background()
-		f, err := os.Open(filepath.Join(opts.Source, src.fname))
-		if err != nil {
-			return err
+	if err := runHook(ctx, hookPostSync, r); err != nil {
+		logger.Error("Hook failed", "error", err)
+		if r.code == Success {
+			r.code = HookFailure
 		}
-		defer func() {
-			if closeErr := f.Close(); closeErr != nil && err == nil {
-				err = closeErr
-			}
-		}()
-
-		var body io.Reader = f
-		contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(src.fname)))
-
-		// Handle gzip compression
-		if src.gzip {
-			pr, pw := io.Pipe()
-			gz := gzip.NewWriter(pw)
-
-			go func() {
-				if _, copyErr := io.Copy(gz, f); copyErr != nil {
-					pw.CloseWithError(fmt.Errorf("compression error: %w", copyErr))
-					return
-				}
-				if closeErr := gz.Close(); closeErr != nil {
-					pw.CloseWithError(fmt.Errorf("gzip close error: %w", closeErr))
-					return
-				}
-				// pw.Close() after successful gz.Close() typically doesn't fail.
-				// If it does, the error will be caught by the Upload call.
-				if closeErr := pw.Close(); closeErr != nil {
-					// Can't call CloseWithError after Close, error will surface in Upload
-					return
-				}
-			}()
-
-			body = pr
+	}
+	if r.code != Success {
+		if err := runHook(ctx, hookOnFailure, r); err != nil {
+			logger.Error("Hook failed", "error", err)
 		}
+	}
 
-		input := &UploadInput{
-			Bucket:               opts.BucketName,
-			Key:                  src.fname,
-			Body:                 body,
-			ContentType:          &contentType,
-			ContentEncoding:      src.getHeader(ContentEncoding),
-			CacheControl:         src.getHeader(CacheControl),
-			ServerSideEncryption: src.getHeader(Encryption),
-		}
+	if r.res != nil || r.code != Success {
+		notifyRun(ctx, r.res, r.code)
+	}
+
+	return r.code
+}
 
-		_, err = u.Upload(ctx, input)
-		return err
+// notifyRun sends the notification of the run, res being nil if it failed before syncing anything.
+// A failed notification is reported but does not fail the run, the files are out already.
+func notifyRun(ctx context.Context, res *uploader.Result, code int) {
+	if err := notify(ctx, res, code); err != nil {
+		logger.Warn("Notifying failed", "error", err)
 	}
 }
 
-func main() {
-	if err := validateCmdLineFlags(opts); err != nil {
-		fmt.Printf("Required field missing: %v.\n\nUsage:\n", err)
-		flag.PrintDefaults()
-		os.Exit(CmdLineOptionError)
+// lockCache locks the local cache file, if it is to be updated, returning the function that
+// unlocks it and the exit code.
+func lockCache() (func(), int) {
+	if opts.CacheRemote != "" || !opts.DoCache || opts.DryRun {
+		return func() {}, Success
 	}
 
-	s3put := s3putGen()
+	lock, err := acquireCacheLock(opts.CacheFile, time.Duration(opts.LockTimeout))
+	if err != nil {
+		logger.Error("Locking failed", "error", err)
+		return nil, LockFailure
+	}
 
-	uploads, rejected := make(chan *sourceFile), &syncedList{}
-	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+	return func() {
+		if err := lock.release(); err != nil {
+			logger.Error("Unlocking failed", "error", err)
+		}
+	}, Success
+}
 
-	current, diff := filesLists()
-	if len(diff) == 0 {
-		say("Nothing to upload.", "Nothing to upload.\n")
-		os.Exit(Success)
+// syncWithPlan runs the pre_sync hook, carries out the plan, publishes it, records the run, then
+// runs the post_upload hook, recording the result of the sync in r and returning the exit code.
+// A sync that rejected or skipped files fails, once what did go out is invalidated, without
+// making the release it uploaded, if any, live.
+func syncWithPlan(ctx context.Context, saved *uploader.Plan, r *hookRun) int {
+	if err := runHook(ctx, hookPreSync, r); err != nil {
+		logger.Error("Hook failed", "error", err)
+		return HookFailure
 	}
-	say(fmt.Sprintf("There are %d files to be uploaded to '%s'", len(diff), opts.BucketName), "Uploading ")
 
-	if !opts.doUpload {
-		say("Skipping upload")
-		goto Cache
+	syncer, err := newSyncer()
+	if err != nil {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
 	}
 
-	wgUploads.Add(len(diff))
-	wgWorkers.Add(opts.WorkersCount)
-	for i := 0; i < opts.WorkersCount; i++ {
-		go upload(s3put, uploads, rejected, wgUploads, wgWorkers)
+	res, err := syncer.Apply(ctx, saved)
+	if errors.Is(err, uploader.ErrPlanOutOfDate) {
+		logger.Error("Plan is out of date, run plan again", "error", err)
+		return PlanFailure
+	} else if err != nil {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
 	}
+	r.res = res
 
-	sort.Strings(diff)
-	for _, fname := range diff {
-		uploads <- newSourceFile(fname)
+	if metrics != nil && !opts.DryRun {
+		metrics.observe(res)
 	}
+	partial := len(res.Rejected) > 0 || len(res.Skipped) > 0
+	code := publish(ctx, res, partial)
 
-	wgUploads.Wait()
-	close(uploads)
-	wgWorkers.Wait()
-	say("Done uploading files.")
+	// The files are out whatever happens to the record, so failing to record the run must not
+	// stop the release or the invalidation, or fail the sync.
+	if err := recordRun(ctx, r.runID, res); err != nil {
+		logger.Warn("Recording the run failed", "error", err)
+	}
+	if code != Success {
+		return code
+	}
+	if partial {
+		logger.Error("Some files were not synced", "rejected", len(res.Rejected), "skipped", len(res.Skipped))
+		return UploadFailure
+	}
+	if err := runHook(ctx, hookPostUpload, r); err != nil {
+		logger.Error("Hook failed", "error", err)
+		return HookFailure
+	}
 
-Cache:
-	if !opts.doCache {
-		say("Skipping cache.")
-		goto Done
+	return Success
+}
+
+// publish makes what the sync uploaded live: promotes the release, if any and complete, then
+// invalidates CloudFront. It returns the exit code.
+func publish(ctx context.Context, res *uploader.Result, partial bool) int {
+	if opts.release != "" && !partial {
+		if err := publishRelease(ctx, res); err != nil {
+			logger.Error("Releasing failed", "error", err)
+			return ReleaseFailure
+		}
+	}
+	if err := invalidate(ctx, res); err != nil {
+		logger.Error("Invalidating CloudFront failed", "error", err)
+		return InvalidationFailure
 	}
 
-	if opts.dryRun {
-		say("Pretending to update cache.")
-		goto Done
+	return Success
+}
+
+// newSyncer returns a syncer for the current options.
+func newSyncer() (*uploader.Syncer, error) {
+	store, err := newCacheStore(opts, s3Uploader)
+	if err != nil {
+		return nil, err
 	}
 
-	current = current.Reject(rejected.list)
-	if err := current.Dump(opts.CacheFile); err != nil {
-		fmt.Println("Caching failed: ", err)
-		os.Exit(CachingFailure)
+	return uploader.New(opts.syncOptions(), s3Uploader, logger, store), nil
+}
+
+// newCacheStore returns the cache store configured by opts: the remote one if opts.CacheRemote
+// is set, the local opts.CacheFile otherwise.
+func newCacheStore(opts *options, up uploader.S3Uploader) (uploader.CacheStore, error) {
+	if opts.release != "" {
+		return emptyCacheStore{}, nil
+	}
+	if opts.CacheRemote == "" {
+		return uploader.NewLocalCacheStore(opts.CacheFile), nil
+	}
+
+	bucket, key, err := uploader.ParseCacheRemote(opts.CacheRemote, opts.BucketName)
+	if err != nil {
+		return nil, err
+	}
+	if up == nil {
+		return nil, fmt.Errorf("s3 uploader is not initialized")
 	}
-	say("Done updating cache.")
 
-Done:
-	say("All done!", " done!\n")
+	return uploader.NewS3CacheStore(up, bucket, key), nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// runIDFormat is the format of the run IDs, the UTC time the run started, to the millisecond.
+const runIDFormat = "20060102T150405.000Z"
+
+// newManifestStore returns the run manifest store configured by opts.RunManifests: under a prefix
+// of a bucket for an s3:// URL, a local folder otherwise.
+func newManifestStore(opts *options, up uploader.S3Uploader) (uploader.ManifestStore, error) {
+	if opts.RunManifests == "" {
+		return nil, fmt.Errorf("no run manifests, set -run-manifests")
+	}
+	if !strings.HasPrefix(opts.RunManifests, "s3://") {
+		return uploader.NewLocalManifestStore(opts.RunManifests), nil
+	}
+
+	bucket, prefix, err := uploader.ParseCacheRemote(opts.RunManifests, opts.BucketName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run manifests location %q", opts.RunManifests)
+	}
+	if up == nil {
+		return nil, fmt.Errorf("s3 uploader is not initialized")
+	}
+
+	return uploader.NewS3ManifestStore(up, bucket, prefix), nil
+}
+
+// recordRun appends the run with the given ID, whose result is res, to the run history, and saves
+// its manifest if opts.RunManifests is set and the run changed anything. Dry runs are not recorded.
+func recordRun(ctx context.Context, id string, res *uploader.Result) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	m := &uploader.RunManifest{ID: id, Bucket: opts.BucketName, Target: opts.Target, CreatedAt: time.Now().UTC(),
+		Version: Version, Files: res.Files, Rejected: res.Rejected, DurationSeconds: res.Duration.Seconds()}
+	if err := uploader.AppendHistory(historyFile(opts.CacheFile), m); err != nil {
+		return fmt.Errorf("run history: %w", err)
+	}
+	if opts.RunManifests == "" || len(m.Files) == 0 {
+		return nil
+	}
+
+	store, err := newManifestStore(opts, s3Uploader)
+	if err != nil {
+		return err
+	}
+	if err := store.Save(ctx, m); err != nil {
+		return err
+	}
+	logger.Info("Saved run manifest", "run", id, "files", len(m.Files))
+
+	return nil
+}
+
+// runRollback restores the objects changed by the run given as the command argument to the
+// versions they had before it (see uploader.Syncer.Rollback), invalidates the objects in CloudFront,
+// then records the rollback as a run of its own. Failing to record it is only warned about.
+func runRollback() int {
+	if len(opts.args) == 0 {
+		fmt.Println("Rollback failed: no run ID given")
+		return CmdLineOptionError
+	}
+
+	ctx := context.Background()
+	store, err := newManifestStore(opts, s3Uploader)
+	if err != nil {
+		fmt.Println("Rollback failed: ", err)
+		return RollbackFailure
+	}
+	m, err := store.Load(ctx, opts.args[0])
+	if err != nil {
+		fmt.Println("Rollback failed: ", err)
+		return RollbackFailure
+	}
+
+	unlock, code := lockCache()
+	if code != Success {
+		return code
+	}
+	defer unlock()
+
+	syncer, err := newSyncer()
+	if err != nil {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
+	}
+	res, err := syncer.Rollback(ctx, m)
+	if errors.Is(err, uploader.ErrCaching) {
+		logger.Error("Caching failed", "error", err)
+		return CachingFailure
+	} else if err != nil {
+		logger.Error("Rollback failed", "error", err)
+		return RollbackFailure
+	}
+	if metrics != nil && !opts.DryRun {
+		metrics.observe(res)
+	}
+
+	invalidateErr := invalidate(ctx, res)
+	if err := recordRun(ctx, time.Now().UTC().Format(runIDFormat), res); err != nil {
+		logger.Warn("Recording the run failed", "error", err)
+	}
+	if invalidateErr != nil {
+		logger.Error("Invalidating CloudFront failed", "error", invalidateErr)
+		return InvalidationFailure
+	}
+	if len(res.Rejected) > 0 {
+		return RollbackFailure
+	}
+
+	return Success
+}
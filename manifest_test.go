@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestRunRollback(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	mock.Versioned = true
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	dir := t.TempDir()
+	opts.Source, opts.CacheFile, opts.RunManifests = filepath.Join(dir, "src"), filepath.Join(dir, "cache.txt"), filepath.Join(dir, "runs")
+	writeSource := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(opts.Source, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(opts.Source, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSource("index.html", "v1")
+	if code := run(); code != Success {
+		t.Fatal("Expected the first run to succeed, got", code)
+	}
+	before := mock.Objects[opts.BucketName+"/index.html"].ETag
+
+	time.Sleep(time.Millisecond) // for the runs to get different IDs
+	writeSource("index.html", "version 2")
+	writeSource("new.html", "new")
+	if code := run(); code != Success {
+		t.Fatal("Expected the second run to succeed, got", code)
+	}
+
+	entries, err := os.ReadDir(opts.RunManifests)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Expected a manifest per run, got %d: %v", len(entries), err)
+	}
+	opts.args = []string{strings.TrimSuffix(entries[1].Name(), ".json")}
+	if code := runRollback(); code != Success {
+		t.Fatal("Expected the rollback to succeed, got", code)
+	}
+	if obj := mock.Objects[opts.BucketName+"/index.html"]; obj == nil || obj.ETag != before {
+		t.Error("Expected index.html to be restored to its first version")
+	}
+	if mock.Objects[opts.BucketName+"/new.html"] != nil {
+		t.Error("Expected new.html to be deleted")
+	}
+
+	opts.args = []string{"unknown"}
+	if code := runRollback(); code != RollbackFailure {
+		t.Error("Expected rolling back an unknown run to fail, got", code)
+	}
+}
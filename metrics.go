@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	metricsNamespace = "go_s3_uploader"
+	// metricsJob is the job the metrics are pushed to the push gateway as.
+	metricsJob = "go_s3_uploader"
+	// lastSuccessMetric is the name of the last success timestamp metric, carried over between runs.
+	lastSuccessMetric = metricsNamespace + "_last_success_timestamp_seconds"
+)
+
+// runMetrics collects the metrics of a run, from the attempts reported by the syncer (see attempt)
+// and the result of the run (see observe), to be served on -metrics-addr, written to -metrics-file
+// in the textfile collector format and/or pushed to the -metrics-push gateway.
+type runMetrics struct {
+	reg   *prometheus.Registry
+	start time.Time
+
+	files       *prometheus.CounterVec
+	bytes       prometheus.Counter
+	retries     *prometheus.CounterVec
+	latency     prometheus.Histogram
+	duration    prometheus.Gauge
+	lastSuccess prometheus.Gauge
+
+	succeeded bool
+}
+
+// metrics is the global run metrics, nil if disabled.
+var metrics *runMetrics
+
+func newRunMetrics() *runMetrics {
+	m := &runMetrics{
+		reg:   prometheus.NewRegistry(),
+		start: time.Now(),
+		files: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "files_total",
+			Help:      "Files processed, by result: uploaded, deleted or failed.",
+		}, []string{"result"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes of the files uploaded.",
+		}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "retries_total",
+			Help:      "Upload retries, by error class: the S3 error code, network or other.",
+		}, []string{"class"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "upload_duration_seconds",
+			Help:      "How long uploading (or deleting) a file took, per attempt.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "run_duration_seconds",
+			Help:      "How long the run took.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: lastSuccessMetric,
+			Help: "When the last successful run finished, as a Unix timestamp.",
+		}),
+	}
+	m.reg.MustRegister(m.files, m.bytes, m.retries, m.latency, m.duration, m.lastSuccess)
+
+	return m
+}
+
+// serve exposes the metrics on addr, in the background, for the rest of the run.
+func (m *runMetrics) serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Serving metrics failed", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// finish records the outcome of the run, given its exit code, then writes the metrics to the
+// textfile and pushes them to the gateway, if asked to.
+func (m *runMetrics) finish(code int, o *options) error {
+	m.duration.Set(time.Since(m.start).Seconds())
+	if code == Success && !o.DryRun {
+		m.succeeded = true
+		m.lastSuccess.SetToCurrentTime()
+	}
+
+	var errs []error
+	if o.MetricsFile != "" {
+		errs = append(errs, m.writeTextfile(o.MetricsFile))
+	}
+	if o.MetricsPush != "" {
+		errs = append(errs, push.New(o.MetricsPush, metricsJob).Gatherer(m.reg).Push())
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeTextfile writes the metrics to fname, in the format of the node exporter's textfile
+// collector. The last success timestamp is carried over from the previous file if this run failed,
+// so that alerting on it keeps working.
+func (m *runMetrics) writeTextfile(fname string) error {
+	if !m.succeeded {
+		if ts, ok := readLastSuccess(fname); ok {
+			m.lastSuccess.Set(ts)
+		}
+	}
+
+	return prometheus.WriteToTextfile(fname, m.reg)
+}
+
+// readLastSuccess returns the last success timestamp written to the fname textfile, if any.
+func readLastSuccess(fname string) (float64, bool) {
+	f, err := os.Open(fname) // #nosec G304 - user given metrics file
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), lastSuccessMetric+" "); ok {
+			ts, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return ts, err == nil && ts > 0
+		}
+	}
+
+	return 0, false
+}
+
+// attempt records an attempt at changing a file, as reported by uploader.Options.OnAttempt:
+// its latency, the bytes uploaded if it succeeded and the retry if it is to be tried again.
+func (m *runMetrics) attempt(a uploader.Attempt) {
+	m.latency.Observe(a.Duration.Seconds())
+	switch {
+	case a.Err == nil:
+		m.bytes.Add(float64(a.Bytes))
+	case a.Retry:
+		m.retries.WithLabelValues(a.Class).Inc()
+	}
+}
+
+// observe counts the files of the result of a run, by outcome.
+func (m *runMetrics) observe(res *uploader.Result) {
+	m.files.WithLabelValues("uploaded").Add(float64(len(res.Uploaded)))
+	m.files.WithLabelValues("deleted").Add(float64(len(res.Deleted)))
+	m.files.WithLabelValues("failed").Add(float64(len(res.Rejected)))
+}
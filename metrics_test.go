@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestRunMetrics(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "go_s3_uploader.prom")
+	m := newRunMetrics()
+
+	m.attempt(uploader.Attempt{Key: "a.html", Bytes: 100, N: 1, Duration: 20 * time.Millisecond})
+	m.attempt(uploader.Attempt{Key: "b.html", N: 1, Duration: 10 * time.Millisecond})
+	m.attempt(uploader.Attempt{Key: "c.html", Bytes: 50, N: 1, Duration: time.Second, Err: errors.New("EOF"), Retry: true, Class: "network"})
+	m.attempt(uploader.Attempt{Key: "c.html", Bytes: 50, N: 2, Duration: time.Second, Err: errors.New("denied"), Class: "AccessDenied"})
+	m.observe(&uploader.Result{Uploaded: []string{"a.html"}, Deleted: []string{"b.html"}, Rejected: []string{"c.html"}})
+
+	if err := m.finish(Success, &options{MetricsFile: fname}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	buf, err := os.ReadFile(fname) // #nosec G304 - test file
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	for _, expected := range []string{
+		`go_s3_uploader_files_total{result="uploaded"} 1`,
+		`go_s3_uploader_files_total{result="deleted"} 1`,
+		`go_s3_uploader_files_total{result="failed"} 1`,
+		`go_s3_uploader_uploaded_bytes_total 100`,
+		`go_s3_uploader_retries_total{class="network"} 1`,
+		`go_s3_uploader_upload_duration_seconds_count 4`,
+		`go_s3_uploader_run_duration_seconds `,
+		`go_s3_uploader_last_success_timestamp_seconds `,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("Expected the metrics to contain %q, got:\n%s", expected, buf)
+		}
+	}
+
+	// A failed run keeps the last success timestamp of the previous one.
+	ts, ok := readLastSuccess(fname)
+	if !ok {
+		t.Fatal("Expected the last success timestamp to be written")
+	}
+	if err := newRunMetrics().finish(CachingFailure, &options{MetricsFile: fname}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if ts2, ok := readLastSuccess(fname); !ok || ts2 != ts {
+		t.Errorf("Expected the last success timestamp %v to be carried over, got %v", ts, ts2)
+	}
+}
+
+func TestRunMetricsFromSync(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	s3Uploader = uploader.NewMockS3Uploader()
+	defer func() { s3Uploader = nil }()
+	metrics = newRunMetrics()
+	defer func() { metrics = nil }()
+
+	fname := filepath.Join(t.TempDir(), "go_s3_uploader.prom")
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.MetricsFile = fname
+	opts.Quiet = true
+
+	code := run()
+	if code != Success {
+		t.Fatal("Expected the sync to succeed, got", code)
+	}
+	if err := metrics.finish(code, opts); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	buf, err := os.ReadFile(fname) // #nosec G304 - test file
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, expected := range []string{
+		`go_s3_uploader_files_total{result="uploaded"} 2`,
+		`go_s3_uploader_upload_duration_seconds_count 2`,
+	} {
+		if !strings.Contains(string(buf), expected) {
+			t.Errorf("Expected the metrics to contain %q, got:\n%s", expected, buf)
+		}
+	}
+}
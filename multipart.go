@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// runMultipartList lists the incomplete multipart uploads in the bucket, oldest first.
+func runMultipartList() int {
+	syncer, err := newSyncer()
+	if err != nil {
+		fmt.Println("Listing the multipart uploads failed: ", err)
+		return MultipartFailure
+	}
+	uploads, err := syncer.MultipartUploads(context.Background())
+	if err != nil {
+		fmt.Println("Listing the multipart uploads failed: ", err)
+		return MultipartFailure
+	}
+
+	return printUploads(uploads)
+}
+
+// runMultipartCleanup aborts the incomplete multipart uploads older than opts.MultipartStaleAfter,
+// whose parts are otherwise kept, and billed, forever. With -dry, it only lists them.
+func runMultipartCleanup() int {
+	syncer, err := newSyncer()
+	if err != nil {
+		fmt.Println("Cleaning up the multipart uploads failed: ", err)
+		return MultipartFailure
+	}
+
+	aborted, err := syncer.AbortStaleUploads(context.Background(), time.Duration(opts.MultipartStaleAfter))
+	if code := printUploads(aborted); code != Success {
+		return code
+	}
+	if err != nil {
+		logger.Error("Cleaning up the multipart uploads failed", "error", err)
+		return MultipartFailure
+	}
+
+	return Success
+}
+
+// printUploads prints the multipart uploads as a table.
+func printUploads(uploads []*uploader.MultipartUpload) int {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tUPLOAD ID\tINITIATED")
+	for _, mu := range uploads {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", mu.Key, mu.UploadID, formatTime(mu.Initiated))
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Println(err)
+		return MultipartFailure
+	}
+
+	return Success
+}
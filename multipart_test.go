@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestOptionsMultipart(t *testing.T) {
+	u := &manager.Uploader{PartSize: manager.DefaultUploadPartSize, Concurrency: manager.DefaultUploadConcurrency, LeavePartsOnError: true}
+	(&options{}).multipart(u)
+	if u.PartSize != manager.DefaultUploadPartSize || u.Concurrency != manager.DefaultUploadConcurrency || u.LeavePartsOnError {
+		t.Errorf("Expected the SDK defaults, without leaving parts, got %+v", u)
+	}
+
+	(&options{PartSize: 64, PartConcurrency: 2, LeavePartsOnError: true}).multipart(u)
+	if u.PartSize != 64<<20 || u.Concurrency != 2 || !u.LeavePartsOnError {
+		t.Errorf("Unexpected multipart settings %+v", u)
+	}
+}
+
+func TestRunMultipartCleanup(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	mock.MultipartUploads = map[string][]*uploader.MultipartUpload{opts.BucketName: {
+		{Key: "video.mp4", UploadID: "1", Initiated: time.Now().Add(-48 * time.Hour)},
+		{Key: "live.mp4", UploadID: "2", Initiated: time.Now()},
+	}}
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+
+	if code := runMultipartList(); code != Success {
+		t.Error("Expected the uploads to be listed, got", code)
+	}
+	if code := runMultipartCleanup(); code != Success {
+		t.Fatal("Expected the cleanup to succeed, got", code)
+	}
+	if len(mock.Aborts) != 1 || mock.Aborts[0].UploadID != "1" {
+		t.Errorf("Expected only the stale upload to be aborted, got %d aborts", len(mock.Aborts))
+	}
+}
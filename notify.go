@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// Notification formats.
+const (
+	notifyGeneric = "generic"
+	notifySlack   = "slack"
+)
+
+const (
+	// notifyTries is how many times a notification is sent before giving up.
+	notifyTries = 3
+	// notifyTimeout bounds each attempt at sending a notification.
+	notifyTimeout = 10 * time.Second
+)
+
+// notifyBackoff returns how long to wait before sending a notification again, after it failed
+// attempts times. Tests replace it to not wait.
+var notifyBackoff = func(attempts int) time.Duration {
+	return time.Duration(attempts) * time.Second
+}
+
+// notification is the generic JSON payload POSTed to opts.NotifyURL when a run is done.
+type notification struct {
+	Bucket    string  `json:"bucket"`
+	Target    string  `json:"target,omitempty"`
+	Success   bool    `json:"success"`
+	ExitCode  int     `json:"exit_code"`
+	Uploaded  int     `json:"uploaded"`
+	Deleted   int     `json:"deleted"`
+	Rejected  int     `json:"rejected"`
+	Skipped   int     `json:"skipped"`
+	Unchanged int     `json:"unchanged"`
+	Duration  float64 `json:"duration_seconds"`
+	Version   string  `json:"version"`
+}
+
+// newNotification describes the run that res is the result of, which exited with code. res is
+// nil if the run failed before syncing anything.
+func newNotification(res *uploader.Result, code int) *notification {
+	if res == nil {
+		res = &uploader.Result{}
+	}
+
+	return &notification{
+		Bucket:    opts.BucketName,
+		Target:    opts.Target,
+		Success:   code == Success && len(res.Rejected) == 0,
+		ExitCode:  code,
+		Uploaded:  len(res.Uploaded),
+		Deleted:   len(res.Deleted),
+		Rejected:  len(res.Rejected),
+		Skipped:   len(res.Skipped),
+		Unchanged: res.Unchanged,
+		Duration:  res.Duration.Seconds(),
+		Version:   GetVersion(),
+	}
+}
+
+// text is the notification as a line of text, for chat.
+func (n *notification) text() string {
+	status, bucket := "succeeded", n.Bucket
+	if !n.Success {
+		status = fmt.Sprintf("failed (exit code %d)", n.ExitCode)
+	}
+	if n.Target != "" {
+		bucket = fmt.Sprintf("%s (%s)", bucket, n.Target)
+	}
+
+	return fmt.Sprintf("Sync to %s %s: %d uploaded, %d deleted, %d rejected, %d skipped, %d unchanged in %s (%s)",
+		bucket, status, n.Uploaded, n.Deleted, n.Rejected, n.Skipped, n.Unchanged,
+		time.Duration(n.Duration*float64(time.Second)).Round(time.Millisecond), n.Version)
+}
+
+// payload returns the notification encoded in the given format.
+func (n *notification) payload(format string) ([]byte, error) {
+	switch format {
+	case "", notifyGeneric:
+		return json.Marshal(n)
+	case notifySlack:
+		return json.Marshal(map[string]string{"text": n.text()})
+	default:
+		return nil, fmt.Errorf("unknown notification format %q", format)
+	}
+}
+
+// notify POSTs the result of the run, which exited with code, to opts.NotifyURL if set,
+// in the opts.NotifyFormat format. res is nil if the run failed before syncing anything.
+// Failed attempts (network errors, 429 and 5xx responses) are retried, up to notifyTries times.
+func notify(ctx context.Context, res *uploader.Result, code int) error {
+	if opts.NotifyURL == "" || opts.DryRun {
+		return nil
+	}
+
+	body, err := newNotification(res, code).payload(opts.NotifyFormat)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := postNotification(ctx, opts.NotifyURL, body)
+		if err == nil {
+			logger.Debug("Sent notification", "url", opts.NotifyURL, "attempt", attempt)
+			return nil
+		}
+		if !retry || attempt >= notifyTries {
+			return err
+		}
+
+		logger.Debug("Retrying notification", "url", opts.NotifyURL, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(notifyBackoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// postNotification POSTs body to url once, returning whether it is worth trying again if it failed.
+func postNotification(ctx context.Context, url string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-s3-uploader/"+Version)
+
+	resp, err := http.DefaultClient.Do(req) // #nosec G107 - user given notification URL
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("notification got %s", resp.Status)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// notifyServer returns a webhook server answering with the given statuses in turn (then 200),
+// and the bodies it was POSTed.
+func notifyServer(t *testing.T, statuses ...int) (*httptest.Server, *[]string) {
+	bodies := &[]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(buf))
+		if len(*bodies) <= len(statuses) {
+			w.WriteHeader(statuses[len(*bodies)-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, bodies
+}
+
+func TestRunNotify(t *testing.T) {
+	saved, savedBackoff := *opts, notifyBackoff
+	defer func() { *opts, notifyBackoff = saved, savedBackoff }()
+	notifyBackoff = func(int) time.Duration { return 0 }
+	s3Uploader = uploader.NewMockS3Uploader()
+	defer func() { s3Uploader = nil }()
+
+	srv, bodies := notifyServer(t, http.StatusServiceUnavailable)
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.NotifyURL = srv.URL
+
+	if code := run(); code != Success {
+		t.Fatal("Expected the run to succeed, got", code)
+	}
+	if len(*bodies) != 2 || (*bodies)[0] != (*bodies)[1] {
+		t.Fatalf("Expected the notification to be retried once, got %q", *bodies)
+	}
+
+	var n notification
+	if err := json.Unmarshal([]byte((*bodies)[1]), &n); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if n.Bucket != opts.BucketName || !n.Success || n.Uploaded != 2 || n.Rejected != 0 || n.Version != GetVersion() {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+
+	// Slack gets a line of text.
+	opts.NotifyFormat = notifySlack
+	if code := run(); code != Success {
+		t.Fatal("Expected the run to succeed, got", code)
+	}
+	if last := (*bodies)[len(*bodies)-1]; !strings.HasPrefix(last, `{"text":"Sync to `+opts.BucketName+` succeeded: 0 uploaded, 0 deleted, 0 rejected, 0 skipped, 2 unchanged in `) {
+		t.Errorf("Unexpected Slack notification %s", last)
+	}
+
+	// A run failing before syncing anything is notified too.
+	lock, err := acquireCacheLock(opts.CacheFile, 0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	defer func() { _ = lock.release() }()
+	opts.NotifyFormat, opts.LockTimeout = notifyGeneric, 0
+	if code := run(); code != LockFailure {
+		t.Fatal("Expected the run to fail, got", code)
+	}
+	n = notification{}
+	if err := json.Unmarshal([]byte((*bodies)[len(*bodies)-1]), &n); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if n.Success || n.ExitCode != LockFailure || n.Uploaded != 0 {
+		t.Errorf("Expected the failure to be notified, got %+v", n)
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+	saved, savedBackoff := *opts, notifyBackoff
+	defer func() { *opts, notifyBackoff = saved, savedBackoff }()
+	notifyBackoff = func(int) time.Duration { return 0 }
+	res := &uploader.Result{}
+
+	// Client errors are not retried.
+	srv, bodies := notifyServer(t, http.StatusBadRequest)
+	opts.NotifyURL = srv.URL
+	if err := notify(t.Context(), res, Success); err == nil || len(*bodies) != 1 {
+		t.Errorf("Expected a single failed attempt, got %v after %d", err, len(*bodies))
+	}
+
+	// Server errors are, up to notifyTries times.
+	srv, bodies = notifyServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	opts.NotifyURL = srv.URL
+	if err := notify(t.Context(), res, Success); err == nil || len(*bodies) != notifyTries {
+		t.Errorf("Expected %d failed attempts, got %v after %d", notifyTries, err, len(*bodies))
+	}
+}
@@ -1,53 +1,141 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-)
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
-type options struct {
-	BucketName string `json:"bucket_name,omitempty"`
-	Source     string `json:"source,omitempty"`
-	CacheFile  string `json:"cache_file,omitempty"`
-	Region     string `json:"region,omitempty"`
-	Profile    string `json:"profile,omitempty"`
-	cfgFile    string
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
 
-	WorkersCount int  `json:"workers_count,omitempty"`
-	Encrypt      bool `json:"encrypt,omitempty"`
+// duration is a time.Duration that reads and writes as a string (e.g. "30s"),
+// both on the command line and in the config file.
+type duration time.Duration
 
-	dryRun, verbose, quiet,
-	doCache, doUpload, saveCfg, version bool
+func (d duration) String() string {
+	return time.Duration(d).String()
 }
 
-func (o *options) dump(fname string) error {
-	f, err := os.Create(fname) // #nosec G304 - file path from user config is expected
+func (d *duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		err2 := f.Close()
-		if err == nil {
-			err = err2
-		} else if err2 != nil {
-			err = fmt.Errorf("%w; %w", err, err2)
-		}
-	}()
+	*d = duration(v)
+
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *duration) UnmarshalText(buf []byte) error {
+	return d.Set(string(buf))
+}
+
+// options holds all the settings, as given on the command line or in the config file.
+// Only cfgFile, planFile, command, saveCfg, printCfg and version (which select what to run)
+// are never persisted.
+type options struct {
+	BucketName  string `json:"bucket_name,omitempty" yaml:"bucket_name,omitempty" toml:"bucket_name,omitempty"`
+	Source      string `json:"source,omitempty" yaml:"source,omitempty" toml:"source,omitempty"`
+	CacheFile   string `json:"cache_file,omitempty" yaml:"cache_file,omitempty" toml:"cache_file,omitempty"`
+	CacheRemote string `json:"cache_remote,omitempty" yaml:"cache_remote,omitempty" toml:"cache_remote,omitempty"`
+	Region      string `json:"region,omitempty" yaml:"region,omitempty" toml:"region,omitempty"`
+	Profile     string `json:"profile,omitempty" yaml:"profile,omitempty" toml:"profile,omitempty"`
+	Target      string `json:"target,omitempty" yaml:"target,omitempty" toml:"target,omitempty"`
+	LogLevel    string `json:"log_level,omitempty" yaml:"log_level,omitempty" toml:"log_level,omitempty"`
+	LogFormat   string `json:"log_format,omitempty" yaml:"log_format,omitempty" toml:"log_format,omitempty"`
+	LogFile     string `json:"log_file,omitempty" yaml:"log_file,omitempty" toml:"log_file,omitempty"`
+	MetricsAddr string `json:"metrics_addr,omitempty" yaml:"metrics_addr,omitempty" toml:"metrics_addr,omitempty"`
+	MetricsFile string `json:"metrics_file,omitempty" yaml:"metrics_file,omitempty" toml:"metrics_file,omitempty"`
+	MetricsPush string `json:"metrics_push,omitempty" yaml:"metrics_push,omitempty" toml:"metrics_push,omitempty"`
+	TraceOTLP   string `json:"trace_otlp,omitempty" yaml:"trace_otlp,omitempty" toml:"trace_otlp,omitempty"`
+	TraceFile   string `json:"trace_file,omitempty" yaml:"trace_file,omitempty" toml:"trace_file,omitempty"`
+
+	CloudFrontDistribution string `json:"cloudfront_distribution,omitempty" yaml:"cloudfront_distribution,omitempty" toml:"cloudfront_distribution,omitempty"`
+	CloudFrontMaxPaths     int    `json:"cloudfront_max_paths" yaml:"cloudfront_max_paths" toml:"cloudfront_max_paths"`
+	CloudFrontWait         bool   `json:"cloudfront_wait" yaml:"cloudfront_wait" toml:"cloudfront_wait"`
+
+	NotifyURL    string `json:"notify_url,omitempty" yaml:"notify_url,omitempty" toml:"notify_url,omitempty"`
+	NotifyFormat string `json:"notify_format,omitempty" yaml:"notify_format,omitempty" toml:"notify_format,omitempty"`
+
+	ReleasePrefix   string `json:"release_prefix,omitempty" yaml:"release_prefix,omitempty" toml:"release_prefix,omitempty"`
+	ReleasePointers string `json:"release_pointers,omitempty" yaml:"release_pointers,omitempty" toml:"release_pointers,omitempty"`
+	ReleaseKeep     int    `json:"release_keep" yaml:"release_keep" toml:"release_keep"`
+	RunManifests    string `json:"run_manifests,omitempty" yaml:"run_manifests,omitempty" toml:"run_manifests,omitempty"`
+
+	cfgFile  string
+	planFile string
+	args     []string // the command arguments, e.g. a release ID
+	release  string   // the ID of the release being uploaded, see runRelease
+
+	WorkersCount int      `json:"workers_count" yaml:"workers_count" toml:"workers_count"`
+	LockTimeout  duration `json:"lock_timeout" yaml:"lock_timeout" toml:"lock_timeout"`
+
+	PartSize            int      `json:"part_size" yaml:"part_size" toml:"part_size"` // in MiB
+	PartConcurrency     int      `json:"part_concurrency" yaml:"part_concurrency" toml:"part_concurrency"`
+	LeavePartsOnError   bool     `json:"leave_parts_on_error" yaml:"leave_parts_on_error" toml:"leave_parts_on_error"`
+	Resume              bool     `json:"resume" yaml:"resume" toml:"resume"`
+	MultipartStaleAfter duration `json:"multipart_stale_after" yaml:"multipart_stale_after" toml:"multipart_stale_after"`
+
+	Encrypt     bool `json:"encrypt" yaml:"encrypt" toml:"encrypt"`
+	Paranoid    bool `json:"paranoid" yaml:"paranoid" toml:"paranoid"`
+	CopyHeaders bool `json:"copy_headers" yaml:"copy_headers" toml:"copy_headers"`
+	Delete      bool `json:"delete" yaml:"delete" toml:"delete"`
+	DryRun      bool `json:"dry_run" yaml:"dry_run" toml:"dry_run"`
+	Verbose     bool `json:"verbose" yaml:"verbose" toml:"verbose"`
+	Quiet       bool `json:"quiet" yaml:"quiet" toml:"quiet"`
+	DoCache     bool `json:"cache" yaml:"cache" toml:"cache"`
+	DoUpload    bool `json:"upload" yaml:"upload" toml:"upload"`
+
+	// Headers overrides the built-in header rules (customHeadersDef).
+	Headers []headerRule `json:"headers,omitempty" yaml:"headers,omitempty" toml:"headers,omitempty"`
+	// Phases are the patterns of the files uploaded after the others, in order (see uploader.Options.Phases).
+	Phases []string `json:"phases,omitempty" yaml:"phases,omitempty" toml:"phases,omitempty"`
+	// Hooks are the shell commands run around a sync.
+	Hooks *hookCommands `json:"hooks,omitempty" yaml:"hooks,omitempty" toml:"hooks,omitempty"`
+	// Targets holds named sets of options (e.g. staging, prod), selected with -target.
+	Targets map[string]*options `json:"targets,omitempty" yaml:"targets,omitempty" toml:"targets,omitempty"`
 
-	buf, err := json.MarshalIndent(o, "", "  ")
+	headersDef []uploader.HeaderRule // compiled from Headers
+	phasesDef  []*regexp.Regexp      // compiled from Phases
+	set        map[string]bool       // config keys explicitly set, see merge
+	overrides  map[string]string     // the flags set in the environment or on the command line, see forTarget
+
+	command                    string
+	saveCfg, printCfg, version bool
+}
+
+// headerRule maps the files matching Pattern (a regular expression) to the given headers.
+type headerRule struct {
+	Pattern string           `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Headers uploader.Headers `json:"headers" yaml:"headers" toml:"headers"`
+}
+
+// dump saves the options to fname, in the format given by its extension (see configFormatOf).
+func (o *options) dump(fname string) error {
+	buf, err := encodeConfig(configFormatOf(fname), o)
 	if err != nil {
 		return err
 	}
-	buf = append(buf, "\n"[0])
 
-	_, err = f.Write(buf)
-
-	return err
+	return os.WriteFile(fname, buf, 0o644) // #nosec G306 - config file is not sensitive
 }
 
+// restore loads the options from fname, in the format given by its extension, and merges them
+// over the current ones. Unknown fields are rejected. A missing file is not an error.
 func (o *options) restore(fname string) error {
-	f, err := os.Open(fname) // #nosec G304 - file path from user config is expected
+	buf, err := os.ReadFile(fname) // #nosec G304 - file path from user config is expected
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -55,16 +143,10 @@ func (o *options) restore(fname string) error {
 
 		return err
 	}
-	defer func() {
-		if err2 := f.Close(); err2 != nil && err == nil {
-			err = err2
-		}
-	}()
 
 	tmp := options{}
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&tmp); err != nil {
-		return err
+	if err = decodeConfig(configFormatOf(fname), buf, &tmp); err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
 	}
 
 	o.merge(&tmp)
@@ -72,28 +154,206 @@ func (o *options) restore(fname string) error {
 	return nil
 }
 
+// merge copies over the settings explicitly set in other, i.e. those present in the config file it
+// was decoded from, even if they are false or zero. If other does not track that (it was built in
+// code), its non-zero settings are copied instead.
 func (o *options) merge(other *options) {
-	if x := other.WorkersCount; x != 0 {
-		o.WorkersCount = x
+	dst, src := reflect.ValueOf(o).Elem(), reflect.ValueOf(other).Elem()
+	for _, f := range reflect.VisibleFields(src.Type()) {
+		key := configKey(f)
+		if key == "" {
+			continue
+		}
+
+		v := src.FieldByIndex(f.Index)
+		if !other.isSet(key, v) {
+			continue
+		}
+
+		dst.FieldByIndex(f.Index).Set(v)
+		if o.set == nil {
+			o.set = map[string]bool{}
+		}
+		o.set[key] = true
+	}
+}
+
+// isSet reports whether the setting with the given config key, whose value is v, is set: present
+// in the config it was loaded from, or not zero if the options were not loaded from a config.
+func (o *options) isSet(key string, v reflect.Value) bool {
+	if o.set != nil {
+		return o.set[key]
+	}
+
+	return !v.IsZero()
+}
+
+// configKey returns the config file key of an options field, or "" if the field is not persisted.
+func configKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if !f.IsExported() || name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+// workers returns the number of upload workers to use: WorkersCount, or twice
+// the number of CPUs if that is 0.
+func (o *options) workers() int {
+	if o.WorkersCount == 0 {
+		return runtime.NumCPU() * 2
+	}
+
+	return o.WorkersCount
+}
+
+// syncOptions returns the options of the syncer, see uploader.Options.
+func (o *options) syncOptions() uploader.Options {
+	so := uploader.Options{
+		Bucket:      o.BucketName,
+		Source:      o.Source,
+		Workers:     o.workers(),
+		Encrypt:     o.Encrypt,
+		Paranoid:    o.Paranoid,
+		CopyHeaders: o.CopyHeaders,
+		Delete:      o.Delete,
+		DryRun:      o.DryRun,
+		SkipUpload:  !o.DoUpload,
+		SkipCache:   !o.DoCache,
+		HeaderRules: o.headersDef,
+		Phases:      o.phasesDef,
+
+		PartSize:          int64(o.PartSize) << 20,
+		PartConcurrency:   o.PartConcurrency,
+		LeavePartsOnError: o.LeavePartsOnError,
+		RecordVersions:    o.RunManifests != "",
+	}
+	if o.Resume {
+		so.ResumeDir = resumeDir(o.CacheFile)
+	}
+	if metrics != nil {
+		so.OnAttempt = metrics.attempt
+	}
+	if o.release != "" {
+		so.Prefix = uploader.NewReleases(nil, o.BucketName, o.ReleasePrefix, nil).Prefix(o.release)
+		so.Delete = false
+	}
+
+	return so
+}
+
+// resumeDir returns the folder the state of the resumable uploads is kept in, next to the given
+// cache file, e.g. .go-s3-uploader.uploads for .go-s3-uploader.txt.
+func resumeDir(cacheFile string) string {
+	return strings.TrimSuffix(cacheFile, filepath.Ext(cacheFile)) + ".uploads"
+}
+
+// multipart configures the multipart uploads of the S3 uploader: the part size and the number of
+// parts uploaded in parallel for each file (the SDK defaults, 5 MiB and 5, if 0), and whether the
+// parts of failed uploads are left in the bucket.
+func (o *options) multipart(u *manager.Uploader) {
+	if o.PartSize > 0 {
+		u.PartSize = int64(o.PartSize) << 20
+	}
+	if o.PartConcurrency > 0 {
+		u.Concurrency = o.PartConcurrency
+	}
+	u.LeavePartsOnError = o.LeavePartsOnError
+}
+
+// compileHeaders compiles the user given header rules and upload phases, if any.
+func (o *options) compileHeaders() error {
+	o.headersDef = nil
+	for _, rule := range o.Headers {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid header rule pattern %q: %w", rule.Pattern, err)
+		}
+		o.headersDef = append(o.headersDef, uploader.HeaderRule{Pattern: re, Headers: rule.Headers})
 	}
-	if x := other.BucketName; x != "" {
-		o.BucketName = x
+
+	o.phasesDef = nil
+	for _, pattern := range o.Phases {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid phase pattern %q: %w", pattern, err)
+		}
+		o.phasesDef = append(o.phasesDef, re)
+	}
+
+	return nil
+}
+
+// targetNames returns the names of the targets selected with -target: a comma separated list
+// of names, or "all" for every one of them.
+func (o *options) targetNames() ([]string, error) {
+	if o.Target == "" {
+		return nil, nil
+	}
+
+	if o.Target == "all" {
+		names := make([]string, 0, len(o.Targets))
+		for name := range o.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return names, nil
 	}
-	if x := other.Source; x != "" {
-		o.Source = x
+
+	names := strings.Split(o.Target, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if _, ok := o.Targets[names[i]]; !ok {
+			return nil, fmt.Errorf("unknown target %q", names[i])
+		}
 	}
-	if x := other.CacheFile; x != "" {
-		o.CacheFile = x
+
+	return names, nil
+}
+
+// forTarget returns a copy of the options, with those of the named target merged on top of the
+// config file ones, but under the environment and the command line: the flags set there are
+// applied again over the target.
+// Unless the target sets its own cache file, it gets one derived from the main cache file
+// (e.g. .go-s3-uploader.prod.txt), so that targets never share a cache, even with -cachefile.
+// The same goes for the plan file.
+func (o *options) forTarget(name string) (*options, error) {
+	t, ok := o.Targets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q", name)
 	}
-	if x := other.Region; x != "" {
-		o.Region = x
+
+	out := *o
+	out.Targets, out.set = nil, nil
+	out.merge(t)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	defineFlags(fs, &out)
+	for flagName, v := range o.overrides {
+		if flagName == "cachefile" {
+			continue
+		}
+		if err := fs.Set(flagName, v); err != nil {
+			return nil, fmt.Errorf("target %s: %w", name, err)
+		}
+	}
+	out.Target = name
+	if t.CacheFile == "" {
+		out.CacheFile = targetFile(o.CacheFile, name)
 	}
-	if x := other.Profile; x != "" {
-		o.Profile = x
+	if o.planFile != "" {
+		out.planFile = targetFile(o.planFile, name)
 	}
-	if x := other.Encrypt; x {
-		o.Encrypt = x
+	if err := out.compileHeaders(); err != nil {
+		return nil, fmt.Errorf("target %s: %w", name, err)
 	}
 
-	// skipping the rest of the fields, they can never come from an unmarshalled file anyway.
+	return &out, nil
+}
+
+// targetFile derives the name of a per target file from the main one, e.g. cache.prod.txt from cache.txt.
+func targetFile(fname, target string) string {
+	ext := filepath.Ext(fname)
+	return strings.TrimSuffix(fname, ext) + "." + target + ext
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+const targetsCfg = `{
+  "bucket_name": "default-bucket",
+  "cache_file": ".go-s3-uploader.txt",
+  "targets": {
+    "staging": {"bucket_name": "staging-bucket", "region": "eu-west-1"},
+    "prod": {
+      "bucket_name": "prod-bucket",
+      "cache_file": "prod-cache.txt",
+      "headers": [{"pattern": "\\.html$", "headers": {"Cache-Control": "max-age=60"}}]
+    }
+  }
+}`
+
+func restoreTestConfig(t *testing.T, cfg string) *options {
+	t.Helper()
+
+	fname := filepath.Join(t.TempDir(), "cfg.json")
+	if err := os.WriteFile(fname, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &options{Region: "us-east-1"}
+	if err := o.restore(fname); err != nil {
+		t.Fatal("Failed to restore config", err)
+	}
+
+	return o
+}
+
+func TestOptionsTargetNames(t *testing.T) {
+	o := restoreTestConfig(t, targetsCfg)
+
+	if names, err := o.targetNames(); err != nil || names != nil {
+		t.Error("Expected no targets to be selected by default, got", names, err)
+	}
+
+	o.Target = "all"
+	if names, _ := o.targetNames(); strings.Join(names, ",") != "prod,staging" {
+		t.Error("Expected all targets to be selected, got", names)
+	}
+
+	o.Target = "staging, prod"
+	if names, _ := o.targetNames(); strings.Join(names, ",") != "staging,prod" {
+		t.Error("Expected staging and prod to be selected, got", names)
+	}
+
+	o.Target = "bogus"
+	if _, err := o.targetNames(); err == nil {
+		t.Error("Expected an unknown target to fail")
+	}
+}
+
+func TestOptionsForTarget(t *testing.T) {
+	o := restoreTestConfig(t, targetsCfg)
+
+	staging, err := o.forTarget("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.BucketName != "staging-bucket" || staging.Region != "eu-west-1" {
+		t.Errorf("Expected the target options to override the base ones, got %+v", staging)
+	}
+	if staging.CacheFile != ".go-s3-uploader.staging.txt" {
+		t.Error("Expected a cache file derived from the target name, got", staging.CacheFile)
+	}
+	if staging.headersDef != nil {
+		t.Error("Expected the default header rules to be used")
+	}
+
+	prod, err := o.forTarget("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.CacheFile != "prod-cache.txt" || prod.Region != "us-east-1" {
+		t.Errorf("Expected the explicit cache file and the base region, got %+v", prod)
+	}
+	if len(prod.headersDef) != 1 {
+		t.Fatal("Expected the target header rules to be compiled, got", prod.headersDef)
+	}
+
+	if o.BucketName != "default-bucket" {
+		t.Error("Expected the base options to be left untouched, got", o.BucketName)
+	}
+}
+
+func TestOptionsCompileHeaders(t *testing.T) {
+	o := &options{Headers: []headerRule{{Pattern: "(", Headers: uploader.Headers{uploader.CacheControl: "x"}}}}
+	if err := o.compileHeaders(); err == nil {
+		t.Error("Expected an invalid pattern to fail")
+	}
+}
+
+func TestOptionsCompilePhases(t *testing.T) {
+	o := &options{Phases: []string{`\.html$`, `^(sitemap\.xml|robots\.txt)$`}}
+	if err := o.compileHeaders(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if phases := o.syncOptions().Phases; len(phases) != 2 || !phases[1].MatchString("robots.txt") {
+		t.Error("Expected the phases to be compiled, got", phases)
+	}
+
+	o.Phases = []string{"("}
+	if err := o.compileHeaders(); err == nil {
+		t.Error("Expected an invalid phase pattern to fail")
+	}
+}
+
+func TestOptionsSyncOptionsResume(t *testing.T) {
+	o := &options{CacheFile: filepath.Join("site", ".go-s3-uploader.txt"), PartSize: 64, PartConcurrency: 3}
+	if so := o.syncOptions(); so.ResumeDir != "" || so.PartSize != 64<<20 || so.PartConcurrency != 3 {
+		t.Errorf("Unexpected sync options %+v", so)
+	}
+
+	o.Resume, o.LeavePartsOnError = true, true
+	if so := o.syncOptions(); so.ResumeDir != filepath.Join("site", ".go-s3-uploader.uploads") || !so.LeavePartsOnError {
+		t.Errorf("Unexpected resume options %+v", so)
+	}
+
+	if o.syncOptions().RecordVersions {
+		t.Error("Expected the versions to be looked up only if the runs are recorded")
+	}
+	o.RunManifests = "runs"
+	if !o.syncOptions().RecordVersions {
+		t.Error("Expected the versions to be looked up for the run manifests")
+	}
+}
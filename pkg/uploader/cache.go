@@ -0,0 +1,405 @@
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVersion is the version of the cache file format written by FileCache.Dump.
+//
+// Version 1 (implicit, no header) was a plain list of "name:md5" lines.
+// Version 2 is a JSON header line followed by one JSON encoded CacheEntry per line.
+const cacheVersion = 2
+
+// cacheHeader is the first line of a versioned cache file.
+type cacheHeader struct {
+	Version int `json:"version"`
+}
+
+// CacheEntry holds what we know about a local file and the remote object it was uploaded to.
+type CacheEntry struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime,omitzero"`
+	MD5         string    `json:"md5"`
+	Key         string    `json:"key,omitempty"`
+	ETag        string    `json:"etag,omitempty"`
+	VersionID   string    `json:"version_id,omitempty"`
+	HeadersHash string    `json:"headers_hash,omitempty"`
+	Gzip        bool      `json:"gzip,omitempty"`
+	UploadedAt  time.Time `json:"uploaded_at,omitzero"`
+
+	// legacy marks entries loaded from the old format, whose md5 was computed over the
+	// file content with the leading and trailing newlines trimmed.
+	legacy bool
+}
+
+// FileCache maps file names (relative to the source folder) to their cache entries.
+type FileCache map[string]*CacheEntry
+
+// changed reports whether the entry differs from the old one, either in content or in the headers
+// it would be uploaded with. Entries migrated from the old format carry no headers hash, in which
+// case only the content is compared.
+func (e *CacheEntry) changed(old *CacheEntry) bool {
+	if old == nil || e.MD5 != old.MD5 {
+		return true
+	}
+
+	return old.HeadersHash != "" && e.HeadersHash != old.HeadersHash
+}
+
+// Load reads the cache from fname, migrating it from the old format if needed.
+// A missing cache file is not an error, it simply leaves the cache empty.
+func (fc FileCache) Load(fname string) error {
+	buf, err := os.ReadFile(fname) // #nosec G304 - file path from user config is expected
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return fc.Parse(buf)
+}
+
+// Parse decodes a cache in either the current or the legacy format.
+func (fc FileCache) Parse(buf []byte) error {
+	buf = bytes.TrimSpace(buf)
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if buf[0] != '{' {
+		return fc.loadV1(buf)
+	}
+
+	return fc.loadV2(buf)
+}
+
+// loadV1 parses the legacy "name:md5" format.
+func (fc FileCache) loadV1(buf []byte) error {
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		idx := strings.LastIndex(line, ":")
+		if idx < 0 {
+			return fmt.Errorf("cache line %d: malformed entry %q", i+1, line)
+		}
+
+		name := line[:idx]
+		fc[name] = &CacheEntry{Name: name, MD5: line[idx+1:], legacy: true}
+	}
+
+	return nil
+}
+
+// loadV2 parses the JSON lines format.
+func (fc FileCache) loadV2(buf []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !sc.Scan() {
+		return sc.Err()
+	}
+
+	hdr := cacheHeader{}
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil {
+		return fmt.Errorf("cache line 1: %w", err)
+	}
+	if hdr.Version != cacheVersion {
+		return fmt.Errorf("unsupported cache version %d", hdr.Version)
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		e := &CacheEntry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return fmt.Errorf("cache line %d: %w", line, err)
+		}
+		fc[e.Name] = e
+	}
+
+	return sc.Err()
+}
+
+// Dump writes the cache to fname, always in the current format, sorted by name.
+// The cache is written to a temporary file first, then renamed over fname, so that
+// a crash midway cannot leave a truncated cache behind.
+func (fc FileCache) Dump(fname string) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = fc.Write(f); err != nil {
+		return err
+	}
+	if err = f.Chmod(0o644); err != nil { // #nosec G302 - the cache is not sensitive
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fname)
+}
+
+// Write encodes the cache in the current format to w.
+func (fc FileCache) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(cacheHeader{Version: cacheVersion}); err != nil {
+		return err
+	}
+
+	for _, name := range fc.Names() {
+		if err := enc.Encode(fc[name]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Names returns the sorted list of file names in the cache.
+func (fc FileCache) Names() []string {
+	names := make([]string, 0, len(fc))
+	for name := range fc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// headersOnly reports whether the only thing that changed since the old entry are the headers,
+// so that they can be updated in place on the remote object. That requires the object to be
+// stored the same way (compressed or not) as before.
+func (e *CacheEntry) headersOnly(old *CacheEntry) bool {
+	return old != nil && old.Key != "" && e.MD5 == old.MD5 && e.Gzip == old.Gzip
+}
+
+// diff returns the names of the files that are new or changed compared to the old cache,
+// split in those that need to be uploaded and those that only need their headers updated.
+func (fc FileCache) diff(old FileCache) (content, hdrs []string) {
+	content, hdrs = []string{}, []string{}
+	for name, e := range fc {
+		o := old[name]
+		switch {
+		case !e.changed(o):
+		case e.headersOnly(o):
+			hdrs = append(hdrs, name)
+		default:
+			content = append(content, name)
+		}
+	}
+
+	return content, hdrs
+}
+
+// inherit copies the remote details (key, etag, version, upload time) over from the old cache,
+// for all the files whose content did not change.
+func (fc FileCache) inherit(old FileCache) {
+	for name, e := range fc {
+		if o := old[name]; o != nil && o.MD5 == e.MD5 {
+			e.Key, e.ETag, e.VersionID, e.UploadedAt = o.Key, o.ETag, o.VersionID, o.UploadedAt
+		}
+	}
+}
+
+// migrate upgrades the legacy entries of the (old) cache, by checking them against the files in
+// root using the legacy hashing. Those that still match get the md5 of the current file, so that
+// moving to the new format does not trigger a full re-upload.
+func (fc FileCache) migrate(root string, current FileCache) error {
+	for name, e := range fc {
+		cur := current[name]
+		if !e.legacy || cur == nil {
+			continue
+		}
+
+		sum, err := legacyFileMD5(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if sum == e.MD5 {
+			e.MD5 = cur.MD5
+		}
+		e.legacy = false
+	}
+
+	return nil
+}
+
+// reject returns all the cache entries that are NOT in the names list.
+func (fc FileCache) reject(names []string) FileCache {
+	rejects := map[string]struct{}{}
+	for _, name := range names {
+		rejects[name] = struct{}{}
+	}
+
+	out := FileCache{}
+	for name, e := range fc {
+		if _, rejected := rejects[name]; !rejected {
+			out[name] = e
+		}
+	}
+
+	return out
+}
+
+// recordUpload stores the remote details of a successfully uploaded source file.
+func (fc FileCache) recordUpload(src *sourceFile) {
+	e := fc[src.fname]
+	if e == nil {
+		return
+	}
+
+	e.Key, e.ETag, e.VersionID, e.UploadedAt = src.key, src.etag, src.versionID, src.uploadedAt
+}
+
+// scanSource walks all the files under the o.Source folder and builds a fresh cache for them.
+//
+// Files whose size and modification time match their entry in the old cache reuse its md5 sum,
+// unless o.Paranoid is set. All the others are hashed, in parallel.
+func scanSource(o *Options, old FileCache) (FileCache, error) {
+	root, fc, toHash := o.Source, FileCache{}, []*CacheEntry{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		src := newSourceFile(o, name)
+		e := &CacheEntry{
+			Name:        name,
+			Size:        info.Size(),
+			ModTime:     info.ModTime().UTC(),
+			HeadersHash: src.headersHash(),
+			Gzip:        src.gzip,
+		}
+		if prev := old[name]; !o.Paranoid && e.sameStat(prev) {
+			e.MD5 = prev.MD5
+		} else {
+			toHash = append(toHash, e)
+		}
+		fc[name] = e
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fc, hashEntries(root, toHash, runtime.NumCPU())
+}
+
+// sameStat reports whether the entry has the same size and modification time as the old one.
+func (e *CacheEntry) sameStat(old *CacheEntry) bool {
+	return old != nil && !old.legacy && !old.ModTime.IsZero() &&
+		e.Size == old.Size && e.ModTime.Equal(old.ModTime)
+}
+
+// hashEntries computes the md5 sums of the given entries, using a pool of workers.
+// It returns the first error encountered, if any.
+func hashEntries(root string, entries []*CacheEntry, workers int) error {
+	jobs, wg := make(chan *CacheEntry), new(sync.WaitGroup)
+	errOnce, firstErr := new(sync.Once), error(nil)
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				sum, err := fileMD5(filepath.Join(root, filepath.FromSlash(e.Name)))
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				e.MD5 = sum
+			}
+		}()
+	}
+
+	for _, e := range entries {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// fileMD5 computes the md5 sum of a file's content.
+func fileMD5(fname string) (sum string, err error) {
+	f, err := os.Open(fname) // #nosec G304 - walking the user given source folder
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	hash := md5.New() // #nosec G401 - used for change detection, not crypto
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// legacyFileMD5 computes the md5 sum the way the old cache format did.
+func legacyFileMD5(fname string) (string, error) {
+	buf, err := os.ReadFile(fname) // #nosec G304 - walking the user given source folder
+	if err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum(bytes.Trim(buf, "\n")) // #nosec G401 - used for change detection, not crypto
+
+	return hex.EncodeToString(sum[:]), nil
+}
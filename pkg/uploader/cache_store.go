@@ -0,0 +1,135 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrCacheConflict is returned when the remote cache was changed by someone else since we loaded it.
+var ErrCacheConflict = errors.New("remote cache was modified by a concurrent run")
+
+// CacheStore abstracts where the cache is persisted.
+type CacheStore interface {
+	// Load returns the cache, or an empty one if there is none yet.
+	Load(ctx context.Context) (FileCache, error)
+	// Save replaces the cache with fc.
+	Save(ctx context.Context, fc FileCache) error
+}
+
+// LocalCacheStore keeps the cache in a local file.
+type LocalCacheStore struct {
+	fname string
+}
+
+// NewLocalCacheStore returns a store that keeps the cache in the fname file.
+func NewLocalCacheStore(fname string) *LocalCacheStore {
+	return &LocalCacheStore{fname: fname}
+}
+
+// Load implements CacheStore.Load.
+func (s *LocalCacheStore) Load(_ context.Context) (FileCache, error) {
+	fc := FileCache{}
+	return fc, fc.Load(s.fname)
+}
+
+// Save implements CacheStore.Save.
+func (s *LocalCacheStore) Save(_ context.Context, fc FileCache) error {
+	return fc.Dump(s.fname)
+}
+
+func (s *LocalCacheStore) String() string {
+	return s.fname
+}
+
+// S3CacheStore keeps the cache as an object in a bucket. It remembers the ETag of the object it
+// loaded, and only saves the cache if the object was not changed in the meantime.
+type S3CacheStore struct {
+	up          S3Uploader
+	bucket, key string
+
+	etag *string // nil if the object did not exist when loaded
+}
+
+// NewS3CacheStore returns a store that keeps the cache in the bucket/key object, using up.
+func NewS3CacheStore(up S3Uploader, bucket, key string) *S3CacheStore {
+	return &S3CacheStore{up: up, bucket: bucket, key: key}
+}
+
+// Load implements CacheStore.Load.
+func (s *S3CacheStore) Load(ctx context.Context) (fc FileCache, err error) {
+	fc, s.etag = FileCache{}, nil
+
+	out, err := s.up.Download(ctx, &DownloadInput{Bucket: s.bucket, Key: s.key})
+	if errors.Is(err, ErrObjectNotFound) {
+		return fc, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := out.Body.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	buf, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = fc.Parse(buf); err != nil {
+		return nil, err
+	}
+	s.etag = out.ETag
+
+	return fc, nil
+}
+
+// Save implements CacheStore.Save. It fails with ErrCacheConflict if the object was changed
+// since it was loaded.
+func (s *S3CacheStore) Save(ctx context.Context, fc FileCache) error {
+	buf := &bytes.Buffer{}
+	if err := fc.Write(buf); err != nil {
+		return err
+	}
+
+	contentType := "application/x-ndjson"
+	input := &UploadInput{Bucket: s.bucket, Key: s.key, Body: buf, ContentType: &contentType}
+	if s.etag != nil {
+		input.IfMatch = s.etag
+	} else {
+		input.IfNoneMatch = stringPtr("*")
+	}
+
+	out, err := s.up.Upload(ctx, input)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return ErrCacheConflict
+	} else if err != nil {
+		return err
+	}
+	s.etag = out.ETag
+
+	return nil
+}
+
+func (s *S3CacheStore) String() string {
+	return "s3://" + s.bucket + "/" + s.key
+}
+
+// ParseCacheRemote parses the remote cache location, which is either a key in the
+// default bucket or a full "s3://bucket/key" URL.
+func ParseCacheRemote(loc, defaultBucket string) (bucket, key string, err error) {
+	if rest, ok := strings.CutPrefix(loc, "s3://"); ok {
+		bucket, key, _ = strings.Cut(rest, "/")
+	} else {
+		bucket, key = defaultBucket, strings.TrimPrefix(loc, "/")
+	}
+
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid remote cache location %q", loc)
+	}
+
+	return bucket, key, nil
+}
@@ -0,0 +1,88 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseCacheRemote(t *testing.T) {
+	tests := []struct {
+		loc, bucket, key string
+		fail             bool
+	}{
+		{"cache/.go-s3-uploader.txt", "default", "cache/.go-s3-uploader.txt", false},
+		{"/cache.txt", "default", "cache.txt", false},
+		{"s3://other/path/cache.txt", "other", "path/cache.txt", false},
+		{"s3://other", "", "", true},
+		{"s3:///cache.txt", "", "", true},
+	}
+
+	for _, tc := range tests {
+		bucket, key, err := ParseCacheRemote(tc.loc, "default")
+		if (err != nil) != tc.fail {
+			t.Errorf("%s: unexpected error state %v", tc.loc, err)
+			continue
+		}
+		if bucket != tc.bucket || key != tc.key {
+			t.Errorf("%s: expected %s/%s got %s/%s", tc.loc, tc.bucket, tc.key, bucket, key)
+		}
+	}
+}
+
+func TestS3CacheStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockS3Uploader()
+	store := &S3CacheStore{up: mock, bucket: "bucket", key: "cache.txt"}
+
+	fc, err := store.Load(ctx)
+	if err != nil || len(fc) != 0 {
+		t.Fatal("Expected a missing remote cache to load empty, got", fc, err)
+	}
+
+	fc["foo.html"] = &CacheEntry{Name: "foo.html", MD5: "1"}
+	if err = store.Save(ctx, fc); err != nil {
+		t.Fatal("Failed to save the remote cache", err)
+	}
+
+	other := &S3CacheStore{up: mock, bucket: "bucket", key: "cache.txt"}
+	loaded, err := other.Load(ctx)
+	if err != nil {
+		t.Fatal("Failed to load the remote cache", err)
+	}
+	if e := loaded["foo.html"]; e == nil || e.MD5 != "1" {
+		t.Error("Expected the saved entry to be loaded back, got", loaded)
+	}
+
+	// A later save from the same store must succeed, as nobody else changed the object.
+	fc["bar.html"] = &CacheEntry{Name: "bar.html", MD5: "2"}
+	if err = store.Save(ctx, fc); err != nil {
+		t.Fatal("Failed to save the remote cache again", err)
+	}
+
+	// Meanwhile the other store is stale.
+	if err = other.Save(ctx, loaded); !errors.Is(err, ErrCacheConflict) {
+		t.Error("Expected a conflict when saving over a changed remote cache, got", err)
+	}
+}
+
+func TestS3CacheStoreConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockS3Uploader()
+	s1 := &S3CacheStore{up: mock, bucket: "bucket", key: "cache.txt"}
+	s2 := &S3CacheStore{up: mock, bucket: "bucket", key: "cache.txt"}
+
+	if _, err := s1.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s1.Save(ctx, FileCache{}); err != nil {
+		t.Fatal("Expected the first save to succeed, got", err)
+	}
+	if err := s2.Save(ctx, FileCache{}); !errors.Is(err, ErrCacheConflict) {
+		t.Error("Expected the second save to conflict, got", err)
+	}
+}
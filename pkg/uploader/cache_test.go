@@ -0,0 +1,177 @@
+package uploader
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCacheLoadLegacy(t *testing.T) {
+	fc := FileCache{}
+	if err := fc.Load(testCacheFile); err != nil {
+		t.Fatal("Expected the legacy cache to load, got", err)
+	}
+
+	if len(fc) != 2 {
+		t.Fatal("Expected 2 entries, got", len(fc))
+	}
+
+	e := fc["foobar.html"]
+	if e == nil || e.Name != "foobar.html" || e.MD5 != "01677e4c0ae5468b9b8b823487f14524" {
+		t.Errorf("Unexpected entry for foobar.html: %+v", e)
+	}
+}
+
+func TestFileCacheLoadMissing(t *testing.T) {
+	fc := FileCache{}
+	if err := fc.Load("../../test/bogus.txt"); err != nil || len(fc) != 0 {
+		t.Error("Expected a missing cache file to load as an empty cache, got", err, fc)
+	}
+}
+
+func TestFileCacheDumpLoad(t *testing.T) {
+	current, err := scanSource(&Options{Source: testSource}, FileCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fname := filepath.Join(t.TempDir(), "cache.txt")
+	if err = current.Dump(fname); err != nil {
+		t.Fatal("Failed to dump cache", err)
+	}
+
+	loaded := FileCache{}
+	if err = loaded.Load(fname); err != nil {
+		t.Fatal("Failed to load cache", err)
+	}
+
+	if diff, hdrDiff := current.diff(loaded); len(diff)+len(hdrDiff) != 0 {
+		t.Error("Expected no differences after a round trip, got", diff, hdrDiff)
+	}
+
+	e := loaded["barbaz.txt"]
+	if e.Size != 8 || e.ModTime.IsZero() || e.HeadersHash == "" {
+		t.Errorf("Expected size, mtime and headers hash to be preserved, got %+v", e)
+	}
+}
+
+func TestFileCacheMigrationDiff(t *testing.T) {
+	old := FileCache{}
+	if err := old.Load(testCacheFile); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := scanSource(&Options{Source: testSource}, FileCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = old.migrate(testSource, current); err != nil {
+		t.Fatal("Failed to migrate cache", err)
+	}
+
+	if diff, hdrDiff := current.diff(old); len(diff)+len(hdrDiff) != 0 {
+		t.Error("Expected a migrated cache to not trigger any uploads, got", diff, hdrDiff)
+	}
+}
+
+func TestFileCacheDiffHeaders(t *testing.T) {
+	old := FileCache{
+		"a.html": {Name: "a.html", MD5: "1", HeadersHash: "h1", Key: "a.html"},
+		"b.html": {Name: "b.html", MD5: "1", HeadersHash: "h1", Key: "b.html"},
+		"c.html": {Name: "c.html", MD5: "1", HeadersHash: "h1", Key: "c.html"},
+		"e.html": {Name: "e.html", MD5: "1", HeadersHash: "h1", Key: "e.html"},
+		"f.html": {Name: "f.html", MD5: "1", HeadersHash: "h1"},
+	}
+	current := FileCache{
+		"a.html": {Name: "a.html", MD5: "1", HeadersHash: "h1"},
+		"b.html": {Name: "b.html", MD5: "1", HeadersHash: "h2"},
+		"c.html": {Name: "c.html", MD5: "2", HeadersHash: "h1"},
+		"d.html": {Name: "d.html", MD5: "1", HeadersHash: "h1"},
+		"e.html": {Name: "e.html", MD5: "1", HeadersHash: "h2", Gzip: true},
+		"f.html": {Name: "f.html", MD5: "1", HeadersHash: "h2"},
+	}
+
+	diff, hdrDiff := current.diff(old)
+	sort.Strings(diff)
+	if expected, actual := "c.html:d.html:e.html:f.html", strings.Join(diff, ":"); expected != actual {
+		t.Errorf("Expected diff %s got %s", expected, actual)
+	}
+	if expected, actual := "b.html", strings.Join(hdrDiff, ":"); expected != actual {
+		t.Errorf("Expected headers diff %s got %s", expected, actual)
+	}
+}
+
+func TestFileCacheReject(t *testing.T) {
+	fc := FileCache{"a": {Name: "a"}, "b": {Name: "b"}}
+	out := fc.reject([]string{"a"})
+
+	if len(out) != 1 || out["b"] == nil {
+		t.Error("Expected only b to remain, got", out.Names())
+	}
+}
+
+func TestScanSourceReusesHashes(t *testing.T) {
+	first, err := scanSource(&Options{Source: testSource}, FileCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := FileCache{}
+	for name, e := range first {
+		cp := *e
+		cp.MD5 = "stale-" + name
+		old[name] = &cp
+	}
+
+	fast, err := scanSource(&Options{Source: testSource}, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5 := fast["barbaz.txt"].MD5; md5 != "stale-barbaz.txt" {
+		t.Error("Expected the cached md5 to be reused for an unchanged file, got", md5)
+	}
+
+	full, err := scanSource(&Options{Source: testSource, Paranoid: true}, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5 := full["barbaz.txt"].MD5; md5 != first["barbaz.txt"].MD5 {
+		t.Error("Expected paranoid mode to rehash the file, got", md5)
+	}
+}
+
+func TestScanSourceRehashesModified(t *testing.T) {
+	old := FileCache{"barbaz.txt": {Name: "barbaz.txt", Size: 8, ModTime: time.Unix(0, 0), MD5: "stale"}}
+
+	current, err := scanSource(&Options{Source: testSource}, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5 := current["barbaz.txt"].MD5; md5 == "stale" {
+		t.Error("Expected a file with a different mtime to be rehashed")
+	}
+}
+
+func TestFileCacheDumpIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "cache.txt")
+
+	fc := FileCache{"a": {Name: "a", MD5: "1"}}
+	if err := fc.Dump(fname); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Dump(fname); err != nil {
+		t.Fatal("Expected to be able to overwrite the cache, got", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Error("Expected no temporary files to be left behind, got", matches)
+	}
+}
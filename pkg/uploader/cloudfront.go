@@ -0,0 +1,170 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// CloudFrontInvalidator abstracts the CloudFront invalidation operations for testability.
+// Implementations include the real AWS SDK v2 one and MockCloudFrontInvalidator.
+type CloudFrontInvalidator interface {
+	// Invalidate creates an invalidation of the given paths.
+	Invalidate(ctx context.Context, input *InvalidateInput) (*InvalidateOutput, error)
+
+	// Wait waits for the invalidation to complete, up to maxWait.
+	Wait(ctx context.Context, distributionID, invalidationID string, maxWait time.Duration) error
+}
+
+// InvalidateInput contains the parameters for a CloudFront invalidation.
+type InvalidateInput struct {
+	DistributionID string
+	// Paths to invalidate, starting with a slash, possibly ending with a "*" wildcard.
+	Paths []string
+	// CallerReference makes the request idempotent, the current time is used if empty.
+	CallerReference string
+}
+
+// InvalidateOutput contains the result of a CloudFront invalidation.
+type InvalidateOutput struct {
+	ID     string
+	Status string
+}
+
+// CloudFrontInvalidatorSDK implements CloudFrontInvalidator using the AWS SDK v2.
+type CloudFrontInvalidatorSDK struct {
+	client *cloudfront.Client
+}
+
+// NewCloudFrontInvalidator creates a new CloudFrontInvalidator backed by the AWS SDK v2.
+func NewCloudFrontInvalidator(cfg *aws.Config) *CloudFrontInvalidatorSDK {
+	return &CloudFrontInvalidatorSDK{client: cloudfront.NewFromConfig(*cfg)}
+}
+
+// Invalidate implements CloudFrontInvalidator.Invalidate.
+func (c *CloudFrontInvalidatorSDK) Invalidate(ctx context.Context, input *InvalidateInput) (*InvalidateOutput, error) {
+	ref := input.CallerReference
+	if ref == "" {
+		ref = fmt.Sprintf("go-s3-uploader-%d", time.Now().UnixNano())
+	}
+
+	out, err := c.client.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(input.DistributionID),
+		InvalidationBatch: &types.InvalidationBatch{
+			CallerReference: aws.String(ref),
+			Paths:           &types.Paths{Quantity: aws.Int32(int32(len(input.Paths))), Items: input.Paths}, // #nosec G115 - bounded by the path limit
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &InvalidateOutput{ID: aws.ToString(out.Invalidation.Id), Status: aws.ToString(out.Invalidation.Status)}, nil
+}
+
+// Wait implements CloudFrontInvalidator.Wait.
+func (c *CloudFrontInvalidatorSDK) Wait(ctx context.Context, distributionID, invalidationID string, maxWait time.Duration) error {
+	w := cloudfront.NewInvalidationCompletedWaiter(c.client)
+	return w.Wait(ctx, &cloudfront.GetInvalidationInput{
+		DistributionId: aws.String(distributionID),
+		Id:             aws.String(invalidationID),
+	}, maxWait)
+}
+
+// InvalidationPaths returns the paths to invalidate for the given keys, collapsed into wildcards
+// if there are more than maxPaths of them. The folders of the index.html files are invalidated
+// too, as that is how they are usually requested.
+//
+// The keys are percent-encoded segment by segment, as CloudFront expects the paths of keys with
+// spaces, percent signs, asterisks or non-ASCII characters to be: a literal "*" becomes "%2A", so
+// that it is not taken for a wildcard.
+//
+// Collapsing replaces the paths at least a given number of folders deep with a wildcard for their
+// folder at that depth, starting from the deepest, until there are at most maxPaths paths, or a
+// single "/*".
+func InvalidationPaths(keys []string, maxPaths int) []string {
+	paths := map[string]bool{}
+	for _, key := range keys {
+		p := "/" + escapePath(strings.TrimPrefix(key, "/"))
+		paths[p] = true
+		if path.Base(p) == "index.html" {
+			paths[strings.TrimSuffix(p, "index.html")] = true
+		}
+	}
+
+	maxDepth := 0
+	for p := range paths {
+		maxDepth = max(maxDepth, depth(p))
+	}
+
+	for level := maxDepth; len(paths) > maxPaths && level >= 0; level-- {
+		paths = collapse(paths, level)
+	}
+
+	out := make([]string, 0, len(paths))
+	for p := range paths {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// escapePath percent-encodes each segment of the key.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// depth returns the number of folders in the path, e.g. 1 for /blog/index.html and /blog/.
+func depth(p string) int {
+	return strings.Count(p, "/") - 1
+}
+
+// collapse replaces the paths at least level deep with a wildcard for their folder at that level,
+// dropping those covered by a wildcard.
+func collapse(paths map[string]bool, level int) map[string]bool {
+	out, prefixes := map[string]bool{}, []string{}
+	for p := range paths {
+		if depth(p) < level {
+			continue
+		}
+
+		segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		prefix := "/" + strings.Join(segments[:level], "/") + "/"
+		if level == 0 {
+			prefix = "/"
+		}
+		if !out[prefix+"*"] {
+			out[prefix+"*"] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	for p := range paths {
+		if depth(p) >= level {
+			continue
+		}
+		covered := false
+		for _, prefix := range prefixes {
+			covered = covered || strings.HasPrefix(p, prefix)
+		}
+		if !covered {
+			out[p] = true
+		}
+	}
+
+	return out
+}
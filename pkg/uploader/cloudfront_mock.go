@@ -0,0 +1,50 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MockCloudFrontInvalidator is a test double for CloudFrontInvalidator that records all the
+// invalidations and waits, and can be configured to fail.
+type MockCloudFrontInvalidator struct {
+	mu sync.Mutex
+
+	// Invalidations records all the invalidation requests in order.
+	Invalidations []*InvalidateInput
+	// Waits records the IDs of the invalidations waited for, in order.
+	Waits []string
+
+	// Err, if set, is returned by all the calls.
+	Err error
+}
+
+// NewMockCloudFrontInvalidator creates a new mock invalidator.
+func NewMockCloudFrontInvalidator() *MockCloudFrontInvalidator {
+	return &MockCloudFrontInvalidator{}
+}
+
+// Invalidate implements CloudFrontInvalidator.Invalidate by recording the request.
+func (m *MockCloudFrontInvalidator) Invalidate(_ context.Context, input *InvalidateInput) (*InvalidateOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Invalidations = append(m.Invalidations, input)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	return &InvalidateOutput{ID: fmt.Sprintf("I%d", len(m.Invalidations)), Status: "InProgress"}, nil
+}
+
+// Wait implements CloudFrontInvalidator.Wait by recording the wait, which completes at once.
+func (m *MockCloudFrontInvalidator) Wait(_ context.Context, _, invalidationID string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Waits = append(m.Waits, invalidationID)
+
+	return m.Err
+}
@@ -0,0 +1,43 @@
+package uploader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInvalidationPaths(t *testing.T) {
+	keys := []string{"index.html", "blog/index.html", "blog/2024/a.html", "blog/2024/b.html", "css/site.css"}
+
+	testCases := []struct {
+		maxPaths int
+		expected string
+	}{
+		{10, "/ /blog/ /blog/2024/a.html /blog/2024/b.html /blog/index.html /css/site.css /index.html"},
+		{6, "/ /blog/ /blog/2024/* /blog/index.html /css/site.css /index.html"},
+		{4, "/ /blog/* /css/* /index.html"},
+		{2, "/*"},
+	}
+
+	for _, tc := range testCases {
+		if actual := strings.Join(InvalidationPaths(keys, tc.maxPaths), " "); actual != tc.expected {
+			t.Errorf("Expected %d paths to give %q, got %q", tc.maxPaths, tc.expected, actual)
+		}
+	}
+
+	if paths := InvalidationPaths(nil, 10); len(paths) != 0 {
+		t.Error("Expected no paths for no keys, got", paths)
+	}
+}
+
+func TestInvalidationPathsEncoding(t *testing.T) {
+	keys := []string{"my docs/a b.html", "100%.html", "img/*.png", "café/index.html", "a+b=c.html"}
+	expected := "/100%25.html /a+b=c.html /caf%C3%A9/ /caf%C3%A9/index.html /img/%2A.png /my%20docs/a%20b.html"
+	if actual := strings.Join(InvalidationPaths(keys, 10), " "); actual != expected {
+		t.Errorf("Expected %q, got %q", expected, actual)
+	}
+
+	expected = "/100%25.html /a+b=c.html /caf%C3%A9/* /img/* /my%20docs/*"
+	if actual := strings.Join(InvalidationPaths(keys, 5), " "); actual != expected {
+		t.Errorf("Expected the encoded paths to collapse to %q, got %q", expected, actual)
+	}
+}
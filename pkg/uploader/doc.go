@@ -0,0 +1,15 @@
+/*
+Package uploader syncs a local folder to an S3 bucket, uploading only the files that changed
+since the last run.
+
+It keeps the md5 sums of the files uploaded in a cache (see CacheStore), compares them with the
+files in the folder to build a Plan, then carries it out with a pool of workers, retrying the
+uploads that fail with recoverable errors:
+
+	store := uploader.NewLocalCacheStore(".go-s3-uploader.txt")
+	s := uploader.New(uploader.Options{Bucket: "example.com", Source: "output"}, up, nil, store)
+	res, err := s.Run(ctx)
+
+where up is an S3Uploader, e.g. the one returned by NewS3Uploader.
+*/
+package uploader
@@ -0,0 +1,98 @@
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// History is the list of past runs, oldest first, as kept by AppendHistory.
+type History []*RunManifest
+
+// Find returns the run with the given ID, nil if there is none.
+func (h History) Find(id string) *RunManifest {
+	for _, m := range h {
+		if m.ID == id {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// Changed returns the runs that changed the object with the given key.
+func (h History) Changed(key string) History {
+	runs := History{}
+	for _, m := range h {
+		if slices.ContainsFunc(m.Files, func(f *RunFile) bool { return f.Key == key }) {
+			runs = append(runs, m)
+		}
+	}
+
+	return runs
+}
+
+// AppendHistory appends the run m to the history file at path, as a line of JSON. The file,
+// and its folder, are created as needed.
+func AppendHistory(path string, m *RunManifest) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 - user given cache folder
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// ReadHistory reads the history file at path. A missing file is an empty history, and a last
+// line cut short, e.g. by a crash while appending it, is ignored.
+func ReadHistory(path string) (h History, err error) {
+	f, err := os.Open(path) // #nosec G304 - user given cache folder
+	if errors.Is(err, os.ErrNotExist) {
+		return History{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := f.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	h = History{}
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return h, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+
+		m := &RunManifest{}
+		if err := json.Unmarshal(line, m); err != nil {
+			return nil, fmt.Errorf("invalid run history %s, line %d: %w", path, n, err)
+		}
+		h = append(h, m)
+	}
+}
@@ -0,0 +1,51 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "history.jsonl")
+	if h, err := ReadHistory(path); err != nil || len(h) != 0 {
+		t.Fatalf("Expected a missing history to be empty, got %v %v", h, err)
+	}
+
+	runs := []*RunManifest{
+		{ID: "1", Bucket: testBucket, Files: []*RunFile{{Action: ActionCreate, Name: "a.html", Key: "a.html", ETag: "e1"}}},
+		{ID: "2", Bucket: testBucket, Files: []*RunFile{{Action: ActionCreate, Name: "b.html", Key: "b.html"}}, Rejected: []string{"c.html"}},
+		{ID: "3", Bucket: testBucket, Files: []*RunFile{{Action: ActionUpdate, Name: "a.html", Key: "a.html", ETag: "e2"}}},
+	}
+	for _, m := range runs {
+		if err := AppendHistory(path, m); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+
+	// A line cut short by a crash is ignored.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":"4","buck`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadHistory(path)
+	if err != nil || len(h) != 3 {
+		t.Fatalf("Expected 3 runs, got %d %v", len(h), err)
+	}
+	if m := h.Find("2"); m == nil || len(m.Rejected) != 1 || m.Rejected[0] != "c.html" {
+		t.Errorf("Expected run 2 with its rejected file, got %+v", m)
+	}
+	if h.Find("4") != nil {
+		t.Error("Expected no run 4")
+	}
+	if changed := h.Changed("a.html"); len(changed) != 2 || changed[1].ID != "3" {
+		t.Errorf("Expected runs 1 and 3 to have changed a.html, got %d", len(changed))
+	}
+}
@@ -0,0 +1,288 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// RunFile is a change made by a run: the action (see PlanFile) and the object it was made to, with
+// its ETag and version ID afterwards (that of the delete marker, for deletions) and the version ID
+// it replaced. Version IDs are only set on versioned buckets.
+//
+// PreviousChecked tells that the object was looked up in the bucket right before the change (see
+// ObjectVersioner): the action is then create only if there was no object, and PreviousVersionID is
+// that of the object replaced. Otherwise the action is the planned one and the version replaced is
+// unknown.
+type RunFile struct {
+	Action            string `json:"action"`
+	Name              string `json:"name"`
+	Key               string `json:"key"`
+	ETag              string `json:"etag,omitempty"`
+	VersionID         string `json:"version_id,omitempty"`
+	PreviousVersionID string `json:"previous_version_id,omitempty"`
+	PreviousChecked   bool   `json:"previous_checked,omitempty"`
+}
+
+// RunManifest records the changes a run made to a bucket, to roll them back (see Syncer.Rollback),
+// and the files it failed to sync, as kept in the run history (see AppendHistory).
+type RunManifest struct {
+	ID              string     `json:"id"`
+	Bucket          string     `json:"bucket"`
+	Target          string     `json:"target,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Version         string     `json:"version,omitempty"`
+	Files           []*RunFile `json:"files"`
+	Rejected        []string   `json:"rejected,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds,omitempty"`
+}
+
+// ManifestStore abstracts where the run manifests are persisted.
+type ManifestStore interface {
+	// Load returns the manifest of the run with the given ID.
+	Load(ctx context.Context, id string) (*RunManifest, error)
+	// Save stores the manifest.
+	Save(ctx context.Context, m *RunManifest) error
+}
+
+// LocalManifestStore keeps the run manifests as JSON files in a local folder.
+type LocalManifestStore struct {
+	dir string
+}
+
+// NewLocalManifestStore returns a store that keeps the run manifests in the dir folder,
+// created as needed.
+func NewLocalManifestStore(dir string) *LocalManifestStore {
+	return &LocalManifestStore{dir: dir}
+}
+
+// Load implements ManifestStore.Load.
+func (s *LocalManifestStore) Load(_ context.Context, id string) (*RunManifest, error) {
+	buf, err := os.ReadFile(filepath.Join(s.dir, manifestName(id))) // #nosec G304 - user given manifest folder
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(buf)
+}
+
+// Save implements ManifestStore.Save.
+func (s *LocalManifestStore) Save(_ context.Context, m *RunManifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(s.dir, manifestName(m.ID)), buf, 0o600)
+}
+
+func (s *LocalManifestStore) String() string {
+	return s.dir
+}
+
+// S3ManifestStore keeps the run manifests as JSON objects under a prefix of a bucket.
+type S3ManifestStore struct {
+	up             S3Uploader
+	bucket, prefix string
+}
+
+// NewS3ManifestStore returns a store that keeps the run manifests under the prefix of the bucket, using up.
+func NewS3ManifestStore(up S3Uploader, bucket, prefix string) *S3ManifestStore {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &S3ManifestStore{up: up, bucket: bucket, prefix: prefix}
+}
+
+// Load implements ManifestStore.Load.
+func (s *S3ManifestStore) Load(ctx context.Context, id string) (m *RunManifest, err error) {
+	out, err := s.up.Download(ctx, &DownloadInput{Bucket: s.bucket, Key: s.prefix + manifestName(id)})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := out.Body.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	buf, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseManifest(buf)
+}
+
+// Save implements ManifestStore.Save.
+func (s *S3ManifestStore) Save(ctx context.Context, m *RunManifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json"
+	_, err = s.up.Upload(ctx, &UploadInput{Bucket: s.bucket, Key: s.prefix + manifestName(m.ID),
+		Body: bytes.NewReader(buf), ContentType: &contentType})
+
+	return err
+}
+
+func (s *S3ManifestStore) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// manifestName returns the name of the file of the run manifest with the given ID.
+func manifestName(id string) string {
+	return id + ".json"
+}
+
+// parseManifest decodes a run manifest.
+func parseManifest(buf []byte) (*RunManifest, error) {
+	m := &RunManifest{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		return nil, fmt.Errorf("invalid run manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// Rollback undoes the changes of the run recorded in m, newest first: the objects it created are
+// deleted, and those it updated or deleted get the version they had before copied over them. That
+// takes a versioned bucket: changes without a previous version are rejected.
+//
+// The files rolled back are dropped from the cache, unless Options.SkipCache is set, so that the
+// next run uploads them again if they are still in the source folder. The changes made are listed
+// in Result.Files, so that a rollback can itself be recorded and rolled back.
+func (s *Syncer) Rollback(ctx context.Context, m *RunManifest) (*Result, error) {
+	if m.Bucket != s.opts.Bucket {
+		return nil, fmt.Errorf("run %s was made to bucket %q, not %q", m.ID, m.Bucket, s.opts.Bucket)
+	}
+
+	start := time.Now()
+	res := &Result{Uploaded: []string{}, Deleted: []string{}, Rejected: []string{}, Keys: []string{}, Files: []*RunFile{}}
+	s.log.Info("Rolling back", "run", m.ID, "bucket", m.Bucket, "files", len(m.Files))
+	for _, f := range slices.Backward(m.Files) {
+		if s.opts.DryRun {
+			s.log.Info("Pretending to roll back", "key", f.Key, "bytes", 0)
+			continue
+		}
+
+		began := time.Now()
+		undo, err := s.rollback(ctx, f)
+		a := Attempt{Key: f.Key, N: 1, Duration: time.Since(began), Err: err}
+		attrs := []any{"key", a.Key, "bytes", 0, "attempt", a.N, "duration", a.Duration}
+		if err != nil {
+			a.Class = errorClass(err)
+			s.attempted(a)
+			res.Rejected = append(res.Rejected, f.Name)
+			s.log.Error("Failed to roll back", append(attrs, "error", err, "error_class", a.Class)...)
+			continue
+		}
+		s.attempted(a)
+
+		if f.Action == ActionCreate {
+			res.Deleted = append(res.Deleted, f.Name)
+			s.log.Info("Deleted", attrs...)
+		} else {
+			res.Uploaded = append(res.Uploaded, f.Name)
+			s.log.Info("Restored", attrs...)
+		}
+		res.Keys, res.Files = append(res.Keys, f.Key), append(res.Files, undo)
+	}
+
+	if !s.opts.DryRun && !s.opts.SkipCache {
+		fc, err := s.loadCache(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCaching, err)
+		}
+		if err := s.saveCache(ctx, fc.reject(append(slices.Clone(res.Uploaded), res.Deleted...))); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCaching, err)
+		}
+	}
+
+	res.Duration = time.Since(start)
+	s.log.Info("All done", "uploaded", len(res.Uploaded), "deleted", len(res.Deleted), "rejected", len(res.Rejected),
+		"duration", res.Duration)
+
+	return res, nil
+}
+
+// rollback undoes the change f, returning the change made to do so, itself undoable: the deletion of
+// a created object, and the update of an updated one or the creation of a deleted one back to the
+// version it had. Created objects are only deleted if they were checked not to exist before the
+// change, so as not to delete one the run merely replaced.
+func (s *Syncer) rollback(ctx context.Context, f *RunFile) (*RunFile, error) {
+	if s.up == nil {
+		return nil, fmt.Errorf("s3 uploader is not initialized")
+	}
+	if f.Action == ActionCreate && !f.PreviousChecked {
+		return nil, errors.New("not deleting the object, it is unknown whether it existed before the run")
+	}
+	if f.Action != ActionCreate && f.PreviousVersionID == "" {
+		return nil, errors.New("no previous version recorded, is the bucket versioned?")
+	}
+
+	undo := &RunFile{Action: ActionUpdate, Name: f.Name, Key: f.Key}
+	prev, exists, checked := s.previousVersion(ctx, f.Key)
+	undo.PreviousVersionID, undo.PreviousChecked = prev, checked
+	if f.Action == ActionCreate {
+		out, err := s.up.Delete(ctx, &DeleteInput{Bucket: s.opts.Bucket, Key: f.Key})
+		if err != nil {
+			return nil, err
+		}
+		undo.Action, undo.VersionID = ActionDelete, aws.ToString(out.VersionID)
+		return undo, nil
+	}
+
+	in := &CopyInput{Bucket: s.opts.Bucket, Key: f.Key, SourceKey: f.Key, SourceVersionID: &f.PreviousVersionID}
+	if s.opts.Encrypt {
+		in.ServerSideEncryption = &sse
+	}
+	out, err := s.up.Copy(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if checked && !exists || !checked && f.Action == ActionDelete {
+		// There was no object, or just a delete marker, which is not a version to go back to.
+		undo.Action = ActionCreate
+	}
+	undo.ETag, undo.VersionID = aws.ToString(out.ETag), aws.ToString(out.VersionID)
+
+	return undo, nil
+}
+
+// previousVersion looks up the object at key, before changing it: the ID of its current version
+// (empty if the bucket is not versioned), whether it exists and whether that could be checked at
+// all, which needs Options.RecordVersions and the S3Uploader to be an ObjectVersioner.
+func (s *Syncer) previousVersion(ctx context.Context, key string) (id string, exists, checked bool) {
+	v, ok := s.up.(ObjectVersioner)
+	if !ok || !s.opts.RecordVersions {
+		return "", false, false
+	}
+
+	id, err := v.CurrentVersion(ctx, &HeadInput{Bucket: s.opts.Bucket, Key: key})
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return "", false, true
+	case err != nil:
+		s.log.Debug("Failed to look up the current version", "object", key, "error", err)
+		return "", false, false
+	}
+
+	return id, true, true
+}
@@ -0,0 +1,185 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncerRollback(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	mock.Versioned = true
+	s := newTestSyncer(t, Options{Delete: true, RecordVersions: true}, mock)
+	manifests := NewLocalManifestStore(filepath.Join(t.TempDir(), "runs"))
+
+	res1, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	first := map[string]*RunFile{}
+	for _, f := range res1.Files {
+		if f.Action != ActionCreate || f.VersionID == "" || f.PreviousVersionID != "" {
+			t.Errorf("Unexpected run file %+v", f)
+		}
+		first[f.Name] = f
+	}
+
+	// An object the next run deletes, and a changed file it updates.
+	out, err := mock.Upload(ctx, &UploadInput{Bucket: testBucket, Key: "gone.html", Body: strings.NewReader("gone")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := s.store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc["gone.html"] = &CacheEntry{Name: "gone.html", MD5: "1", Key: "gone.html", VersionID: *out.VersionID}
+	fc["foobar.html"].MD5, fc["foobar.html"].Size = "changed", 0
+	if err := s.store.Save(ctx, fc); err != nil {
+		t.Fatal(err)
+	}
+
+	res2, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := manifests.Save(ctx, &RunManifest{ID: "2", Bucket: testBucket, Files: res2.Files}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	m, err := manifests.Load(ctx, "2")
+	if err != nil || len(m.Files) != 2 {
+		t.Fatalf("Expected the manifest of the second run, got %+v %v", m, err)
+	}
+
+	res, err := s.Rollback(ctx, m)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(sorted(res.Uploaded), ":") != "foobar.html:gone.html" || len(res.Rejected) != 0 {
+		t.Errorf("Expected both files to be restored, got %+v", res)
+	}
+	if obj := mock.Objects[testBucket+"/gone.html"]; obj == nil || string(obj.Content) != "gone" {
+		t.Error("Expected the deleted object to be restored, got", obj)
+	}
+	for _, f := range res.Files {
+		if want := map[string]string{"gone.html": ActionCreate, "foobar.html": ActionUpdate}[f.Name]; f.Action != want || f.VersionID == "" {
+			t.Errorf("Expected the rollback of %s to be recorded as %s, got %+v", f.Name, want, f)
+		}
+	}
+	for _, cp := range mock.ObjectCopies {
+		if cp.Key == "foobar.html" && *cp.SourceVersionID != first["foobar.html"].VersionID {
+			t.Errorf("Expected foobar.html to be restored to %s, got %s", first["foobar.html"].VersionID, *cp.SourceVersionID)
+		}
+	}
+	if fc, _ := s.store.Load(ctx); fc["foobar.html"] != nil || fc["barbaz.txt"] == nil {
+		t.Error("Expected the files rolled back to be dropped from the cache, got", fc.Names())
+	}
+
+	// Rolling back the first run deletes the objects it created.
+	if _, err := s.Rollback(ctx, &RunManifest{ID: "1", Bucket: testBucket, Files: res1.Files}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if mock.Objects[testBucket+"/barbaz.txt"] != nil {
+		t.Error("Expected the created object to be deleted")
+	}
+}
+
+func TestSyncerRollbackUnversioned(t *testing.T) {
+	s := newTestSyncer(t, Options{}, NewMockS3Uploader())
+
+	m := &RunManifest{ID: "1", Bucket: testBucket, Files: []*RunFile{{Action: ActionUpdate, Name: "a.html", Key: "a.html"}}}
+	res, err := s.Rollback(context.Background(), m)
+	if err != nil || strings.Join(res.Rejected, ":") != "a.html" {
+		t.Errorf("Expected the update without a previous version to be rejected, got %+v %v", res, err)
+	}
+
+	m.Bucket = "other"
+	if _, err := s.Rollback(context.Background(), m); err == nil {
+		t.Error("Expected rolling back a run to another bucket to fail")
+	}
+}
+
+func TestSyncerRollbackMissingCache(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	mock.Versioned = true
+	s := newTestSyncer(t, Options{RecordVersions: true}, mock)
+
+	// The object is already in the bucket, but the cache is missing, so the run plans to create it.
+	out, err := mock.Upload(ctx, &UploadInput{Bucket: testBucket, Key: "foobar.html", Body: strings.NewReader("live")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, f := range res.Files {
+		if f.Name == "foobar.html" && (f.Action != ActionUpdate || f.PreviousVersionID != *out.VersionID || !f.PreviousChecked) {
+			t.Errorf("Expected the existing object to be recorded as updated from %s, got %+v", *out.VersionID, f)
+		}
+		if f.Name == "barbaz.txt" && (f.Action != ActionCreate || !f.PreviousChecked) {
+			t.Errorf("Expected the new object to be recorded as created, got %+v", f)
+		}
+	}
+
+	// Rolling back restores the live object instead of deleting it.
+	if _, err := s.Rollback(ctx, &RunManifest{ID: "1", Bucket: testBucket, Files: res.Files}); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if obj := mock.Objects[testBucket+"/foobar.html"]; obj == nil || string(obj.Content) != "live" {
+		t.Error("Expected the live object to be restored, got", obj)
+	}
+	if mock.Objects[testBucket+"/barbaz.txt"] != nil {
+		t.Error("Expected the created object to be deleted")
+	}
+}
+
+func TestSyncerRollbackUnchecked(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	mock.Versioned = true
+	mock.HeadErrorFunc = func(*HeadInput) error { return errors.New("access denied") }
+	s := newTestSyncer(t, Options{RecordVersions: true}, mock)
+
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, f := range res.Files {
+		if f.Action != ActionCreate || f.PreviousChecked {
+			t.Errorf("Expected the planned action to be recorded, unchecked, got %+v", f)
+		}
+	}
+
+	// Whether the objects existed before is unknown, so they are not deleted.
+	res, err = s.Rollback(ctx, &RunManifest{ID: "1", Bucket: testBucket, Files: res.Files})
+	if err != nil || len(res.Rejected) != 2 {
+		t.Fatalf("Expected the unchecked creations to be rejected, got %+v %v", res, err)
+	}
+	if len(mock.Deletes) != 0 {
+		t.Error("Expected nothing to be deleted, got", len(mock.Deletes))
+	}
+}
+
+func TestSyncerRecordVersionsOff(t *testing.T) {
+	mock, heads := NewMockS3Uploader(), 0
+	mock.HeadErrorFunc = func(*HeadInput) error {
+		heads++
+		return nil
+	}
+	s := newTestSyncer(t, Options{}, mock)
+
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if heads != 0 {
+		t.Error("Expected no version lookups, got", heads)
+	}
+	for _, f := range res.Files {
+		if f.Action != ActionCreate || f.PreviousChecked {
+			t.Errorf("Expected the planned action to be recorded, unchecked, got %+v", f)
+		}
+	}
+}
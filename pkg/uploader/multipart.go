@@ -0,0 +1,71 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MultipartUploads lists the multipart uploads in progress, or left behind by failed uploads,
+// under Options.Prefix in the bucket, oldest first.
+func (s *Syncer) MultipartUploads(ctx context.Context) ([]*MultipartUpload, error) {
+	if s.up == nil {
+		return nil, fmt.Errorf("s3 uploader is not initialized")
+	}
+
+	uploads, err := s.up.ListMultipartUploads(ctx, &ListMultipartUploadsInput{Bucket: s.opts.Bucket, Prefix: s.opts.Prefix})
+	if err != nil {
+		return nil, err
+	}
+	sortUploads(uploads)
+
+	return uploads, nil
+}
+
+// AbortStaleUploads aborts the multipart uploads started more than olderThan ago, whose parts S3
+// keeps (and bills) until then, returning those aborted. With Options.DryRun, they are only
+// logged. Failures to abort do not stop the others, they are all returned joined. The log records
+// name the object with an "object" attribute, not "key", as they are not about uploads.
+func (s *Syncer) AbortStaleUploads(ctx context.Context, olderThan time.Duration) ([]*MultipartUpload, error) {
+	uploads, err := s.MultipartUploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var aborted []*MultipartUpload
+	var errs []error
+	for _, mu := range uploads {
+		age := time.Since(mu.Initiated)
+		if age < olderThan {
+			continue
+		}
+		if s.opts.DryRun {
+			s.log.Info("Pretending to abort", "object", mu.Key, "upload_id", mu.UploadID, "age", age.Round(time.Second))
+			aborted = append(aborted, mu)
+			continue
+		}
+
+		if err := s.up.AbortMultipartUpload(ctx, &AbortMultipartUploadInput{Bucket: s.opts.Bucket, Key: mu.Key, UploadID: mu.UploadID}); err != nil {
+			s.log.Error("Failed to abort", "object", mu.Key, "upload_id", mu.UploadID, "error", err, "error_class", errorClass(err))
+			errs = append(errs, fmt.Errorf("%s: %w", mu.Key, err))
+			continue
+		}
+		s.log.Info("Aborted", "object", mu.Key, "upload_id", mu.UploadID, "age", age.Round(time.Second))
+		aborted = append(aborted, mu)
+	}
+
+	return aborted, errors.Join(errs...)
+}
+
+// sortUploads sorts the multipart uploads by start time, then key.
+func sortUploads(uploads []*MultipartUpload) {
+	slices.SortFunc(uploads, func(a, b *MultipartUpload) int {
+		if c := a.Initiated.Compare(b.Initiated); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+}
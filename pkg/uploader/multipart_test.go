@@ -0,0 +1,43 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncerAbortStaleUploads(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	now := time.Now()
+	mock.MultipartUploads = map[string][]*MultipartUpload{testBucket: {
+		{Key: "fresh.mp4", UploadID: "1", Initiated: now.Add(-time.Hour)},
+		{Key: "stale.mp4", UploadID: "2", Initiated: now.Add(-48 * time.Hour)},
+		{Key: "failing.mp4", UploadID: "3", Initiated: now.Add(-72 * time.Hour)},
+	}}
+
+	s := newTestSyncer(t, Options{DryRun: true}, mock)
+	uploads, err := s.MultipartUploads(ctx)
+	if err != nil || len(uploads) != 3 || uploads[0].Key != "failing.mp4" {
+		t.Fatalf("Expected the uploads, oldest first, got %v %v", uploads, err)
+	}
+	aborted, err := s.AbortStaleUploads(ctx, 24*time.Hour)
+	if err != nil || len(aborted) != 2 || len(mock.Aborts) != 0 {
+		t.Fatalf("Expected the dry run to abort nothing, got %d aborts, %v", len(mock.Aborts), err)
+	}
+
+	mock.ErrorFunc = func(input *UploadInput) error {
+		if input.Key == "failing.mp4" {
+			return errors.New("AccessDenied")
+		}
+		return nil
+	}
+	s = newTestSyncer(t, Options{}, mock)
+	aborted, err = s.AbortStaleUploads(ctx, 24*time.Hour)
+	if err == nil || len(aborted) != 1 || aborted[0].Key != "stale.mp4" {
+		t.Errorf("Expected stale.mp4 to be aborted and failing.mp4 to fail, got %v %v", aborted, err)
+	}
+	if left := mock.MultipartUploads[testBucket]; len(left) != 2 {
+		t.Errorf("Expected 2 uploads left, got %d", len(left))
+	}
+}
@@ -0,0 +1,65 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+)
+
+// phases splits the files into the phases they are uploaded in, in order: the files matching
+// none of Options.Phases first, then those matching each of them in turn (the first match wins)
+// and, last, the deletions. Empty phases are left out.
+func (s *Syncer) phases(srcs []*sourceFile) [][]*sourceFile {
+	phases := make([][]*sourceFile, len(s.opts.Phases)+2)
+	for _, src := range srcs {
+		i := 0
+		if src.delete {
+			i = len(phases) - 1
+		} else {
+			for j, re := range s.opts.Phases {
+				if re.MatchString(src.fname) {
+					i = j + 1
+					break
+				}
+			}
+		}
+		phases[i] = append(phases[i], src)
+	}
+
+	out := phases[:0]
+	for _, phase := range phases {
+		if len(phase) > 0 {
+			out = append(out, phase)
+		}
+	}
+
+	return out
+}
+
+// uploadPhases uploads the files phase by phase (see phases), each one only once the previous one
+// fully succeeded. It returns the names of the files skipped because a phase failed.
+func (s *Syncer) uploadPhases(ctx context.Context, srcs []*sourceFile, rejected *syncedList) []string {
+	phases := s.phases(srcs)
+	for i, phase := range phases {
+		if len(phases) > 1 {
+			s.log.Debug("Uploading phase", "phase", i+1, "phases", len(phases), "files", len(phase))
+		}
+		s.uploadAll(ctx, phase, rejected)
+
+		if len(rejected.list) == 0 || i == len(phases)-1 {
+			continue
+		}
+
+		var skipped []string
+		for _, rest := range phases[i+1:] {
+			for _, src := range rest {
+				skipped = append(skipped, src.fname)
+			}
+		}
+		s.log.Error("Skipping the next phases", "phase", i+1, "skipped", len(skipped),
+			"error", fmt.Errorf("%d files failed", len(rejected.list)))
+
+		return skipped
+	}
+
+	return nil
+}
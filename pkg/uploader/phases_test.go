@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSyncerPhases(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{Delete: true, Workers: 4, Phases: []*regexp.Regexp{regexp.MustCompile(`\.txt$`)}}, mock)
+	if err := s.store.Save(ctx, FileCache{"gone.html": &CacheEntry{Name: "gone.html", MD5: "1", Key: "gone.html"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ErrorFunc is called under the mock's lock, for uploads and deletes alike.
+	var order []string
+	mock.ErrorFunc = func(input *UploadInput) error {
+		order = append(order, input.Key)
+		return nil
+	}
+
+	if _, err := s.Run(ctx); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(order, ":") != "foobar.html:barbaz.txt:gone.html" {
+		t.Error("Expected the unmatched files, then the .txt ones, then the deletions, got", order)
+	}
+}
+
+func TestSyncerPhaseFailed(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{Delete: true, Phases: []*regexp.Regexp{regexp.MustCompile(`\.txt$`)}}, mock)
+	if err := s.store.Save(ctx, FileCache{"gone.html": &CacheEntry{Name: "gone.html", MD5: "1", Key: "gone.html"}}); err != nil {
+		t.Fatal(err)
+	}
+	mock.ErrorFunc = ErrorOnKey("foobar.html", NewAccessDeniedError())
+
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(res.Rejected, ":") != "foobar.html" || strings.Join(res.Skipped, ":") != "barbaz.txt:gone.html" ||
+		len(res.Uploaded)+len(res.Deleted)+len(res.Keys) != 0 {
+		t.Errorf("Expected the next phases to be skipped, got %+v", res)
+	}
+	if mock.UploadCount != 1 || len(mock.Deletes) != 0 {
+		t.Errorf("Expected a single attempt, got %d uploads and %d deletes", mock.UploadCount, len(mock.Deletes))
+	}
+
+	// The skipped files are left for the next run.
+	fc, err := s.store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fc.Names(), ":") != "gone.html" {
+		t.Error("Expected only the file left to delete in the cache, got", fc.Names())
+	}
+}
@@ -0,0 +1,361 @@
+package uploader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// planVersion is the version of the plan file format written by Plan.Dump.
+const planVersion = 1
+
+// Plan actions, for each file.
+const (
+	ActionCreate        = "create"
+	ActionUpdate        = "update"
+	ActionUpdateHeaders = "update-headers"
+	ActionDelete        = "delete"
+)
+
+// Plan is the list of changes a sync would make to the bucket. It can be saved (see Dump),
+// reviewed, and carried out later on by Syncer.Apply, as long as the source folder did not change meanwhile.
+type Plan struct {
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"created_at"`
+	Bucket    string      `json:"bucket"`
+	Source    string      `json:"source"`
+	Encrypt   bool        `json:"encrypt,omitempty"`
+	Files     []*PlanFile `json:"files"`
+	Summary   PlanSummary `json:"summary"`
+}
+
+// PlanFile is the change planned for a single file.
+type PlanFile struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	MD5    string `json:"md5,omitempty"`
+	// Size is the size of the file, UploadSize the number of bytes sent, after compression.
+	// UploadSize is only known for measured plans (see Measure).
+	Size       int64   `json:"size"`
+	UploadSize int64   `json:"upload_size,omitempty"`
+	Headers    Headers `json:"headers,omitempty"`
+}
+
+// PlanSummary counts the planned changes, and the bytes to be sent for them.
+type PlanSummary struct {
+	Create        int   `json:"create"`
+	Update        int   `json:"update"`
+	UpdateHeaders int   `json:"update_headers"`
+	Delete        int   `json:"delete"`
+	Bytes         int64 `json:"bytes"`
+	UploadBytes   int64 `json:"upload_bytes,omitempty"`
+}
+
+// newPlan builds the plan for the given file lists (see Syncer.filesLists): new and changed files
+// are uploaded, those in hdrDiff have their headers updated and, if o.Delete is set, the
+// objects of the files no longer in the source folder are deleted.
+func newPlan(o *Options, current, old FileCache, diff, hdrDiff []string) *Plan {
+	p := &Plan{
+		Version:   planVersion,
+		CreatedAt: time.Now().UTC(),
+		Bucket:    o.Bucket,
+		Source:    o.Source,
+		Encrypt:   o.Encrypt,
+		Files:     []*PlanFile{},
+	}
+
+	for _, fname := range sorted(diff) {
+		action := ActionUpdate
+		if old[fname] == nil {
+			action = ActionCreate
+		}
+		p.add(action, newSourceFile(o, fname), current[fname])
+	}
+	for _, fname := range sorted(hdrDiff) {
+		p.add(ActionUpdateHeaders, newSourceFile(o, fname), current[fname])
+	}
+
+	if o.Delete {
+		deleted := []string{}
+		for fname := range old {
+			if current[fname] == nil {
+				deleted = append(deleted, fname)
+			}
+		}
+		for _, fname := range sorted(deleted) {
+			src := &sourceFile{fname: fname, key: o.Prefix + fname, delete: true}
+			if e := old[fname]; e.Key != "" {
+				src.key = e.Key
+			}
+			p.add(ActionDelete, src, nil)
+		}
+	}
+
+	return p
+}
+
+// add appends the planned change for src, whose cache entry is e (nil for deletions).
+func (p *Plan) add(action string, src *sourceFile, e *CacheEntry) {
+	f := &PlanFile{Action: action, Name: src.fname, Key: src.key}
+	if e != nil {
+		f.MD5, f.Size, f.Headers = e.MD5, e.Size, src.hdrs
+	}
+	p.Files = append(p.Files, f)
+
+	switch action {
+	case ActionCreate:
+		p.Summary.Create++
+	case ActionUpdate:
+		p.Summary.Update++
+	case ActionUpdateHeaders:
+		p.Summary.UpdateHeaders++
+	case ActionDelete:
+		p.Summary.Delete++
+	}
+	if action == ActionCreate || action == ActionUpdate {
+		p.Summary.Bytes += f.Size
+	}
+}
+
+// Empty reports whether there is nothing to do.
+func (p *Plan) Empty() bool {
+	return len(p.Files) == 0
+}
+
+// Measure computes the number of bytes to be sent for each upload, compressing the files
+// that are to be gzipped.
+func (p *Plan) Measure() error {
+	p.Summary.UploadBytes = 0
+	for _, f := range p.Files {
+		if f.Action != ActionCreate && f.Action != ActionUpdate {
+			continue
+		}
+
+		f.UploadSize = f.Size
+		if f.gzip() {
+			n, err := gzippedSize(filepath.Join(p.Source, f.Name))
+			if err != nil {
+				return err
+			}
+			f.UploadSize = n
+		}
+		p.Summary.UploadBytes += f.UploadSize
+	}
+
+	return nil
+}
+
+// gzippedSize returns the size of the file, once compressed.
+func gzippedSize(fname string) (n int64, err error) {
+	f, err := os.Open(fname) // #nosec G304 - files from the source folder are expected
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	cw := &countingWriter{}
+	gz := gzip.NewWriter(cw)
+	if _, err = io.Copy(gz, f); err != nil {
+		return 0, err
+	}
+	if err = gz.Close(); err != nil {
+		return 0, err
+	}
+
+	return cw.n, nil
+}
+
+// countingWriter discards what is written to it, only counting the bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// gzip reports whether the file is to be uploaded compressed.
+func (f *PlanFile) gzip() bool {
+	return f.Headers[ContentEncoding] == "gzip"
+}
+
+// sourceFile returns the source file that carries out the planned change.
+func (f *PlanFile) sourceFile(source string, encrypt bool) *sourceFile {
+	return &sourceFile{
+		fname:       f.Name,
+		fpath:       filepath.Join(source, f.Name),
+		key:         f.Key,
+		size:        f.Size,
+		md5:         f.MD5,
+		hdrs:        f.Headers,
+		encrypt:     encrypt,
+		gzip:        f.gzip(),
+		action:      f.Action,
+		headersOnly: f.Action == ActionUpdateHeaders,
+		delete:      f.Action == ActionDelete,
+	}
+}
+
+// sources returns the source files that carry out the plan.
+func (p *Plan) sources() []*sourceFile {
+	srcs := make([]*sourceFile, 0, len(p.Files))
+	for _, f := range p.Files {
+		srcs = append(srcs, f.sourceFile(p.Source, p.Encrypt))
+	}
+
+	return srcs
+}
+
+// Matches checks that the other, freshly computed, plan makes the exact same changes as p.
+// Only the details that are computed again (e.g. not the creation time) are compared.
+func (p *Plan) Matches(other *Plan) error {
+	switch {
+	case p.Bucket != other.Bucket:
+		return fmt.Errorf("bucket changed from %q to %q", p.Bucket, other.Bucket)
+	case p.Source != other.Source:
+		return fmt.Errorf("source changed from %q to %q", p.Source, other.Source)
+	case p.Encrypt != other.Encrypt:
+		return fmt.Errorf("encryption changed from %t to %t", p.Encrypt, other.Encrypt)
+	case len(p.Files) != len(other.Files):
+		return fmt.Errorf("%d changes planned, %d found now", len(p.Files), len(other.Files))
+	}
+
+	for i, f := range p.Files {
+		if err := f.matches(other.Files[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches checks that the other, freshly computed, change is the same as f, naming what differs.
+func (f *PlanFile) matches(o *PlanFile) error {
+	switch {
+	case f.Name != o.Name:
+		return fmt.Errorf("planned to %s %s, found %s to %s now", f.Action, f.Name, o.Name, o.Action)
+	case f.Action != o.Action:
+		return fmt.Errorf("%s: planned to %s, found %s now", f.Name, f.Action, o.Action)
+	case f.Key != o.Key:
+		return fmt.Errorf("%s: key changed from %q to %q", f.Name, f.Key, o.Key)
+	case f.MD5 != o.MD5:
+		return fmt.Errorf("%s: content changed (md5 %s, now %s)", f.Name, f.MD5, o.MD5)
+	case !f.Headers.equal(o.Headers):
+		return fmt.Errorf("%s: headers changed from %v to %v", f.Name, f.Headers, o.Headers)
+	}
+
+	return nil
+}
+
+// Print writes the plan in a human readable form to w.
+func (p *Plan) Print(w io.Writer) {
+	if p.Empty() {
+		fmt.Fprintf(w, "No changes. '%s' is up to date with %s.\n", p.Bucket, p.Source)
+		return
+	}
+
+	fmt.Fprintf(w, "Changes to '%s', from %s:\n\n", p.Bucket, p.Source)
+	for _, f := range p.Files {
+		switch f.Action {
+		case ActionCreate, ActionUpdate:
+			symbol := "+"
+			if f.Action == ActionUpdate {
+				symbol = "~"
+			}
+			fmt.Fprintf(w, "  %s %s -> %s (%s)\n", symbol, f.Name, f.Key, f.sizes())
+		case ActionUpdateHeaders:
+			fmt.Fprintf(w, "  ~ %s -> %s (headers only)\n", f.Name, f.Key)
+		case ActionDelete:
+			fmt.Fprintf(w, "  - %s\n", f.Key)
+			continue
+		}
+
+		names := make([]string, 0, len(f.Headers))
+		for name := range f.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "      %s: %s\n", name, f.Headers[name])
+		}
+		if p.Encrypt {
+			fmt.Fprintf(w, "      Server-Side-Encryption: %s\n", sse)
+		}
+	}
+
+	s := p.Summary
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to update headers, %d to delete.\n",
+		s.Create, s.Update, s.UpdateHeaders, s.Delete)
+	if s.UploadBytes > 0 || s.Bytes == 0 {
+		fmt.Fprintf(w, "Bytes: %s to upload, %s after compression.\n", FormatBytes(s.Bytes), FormatBytes(s.UploadBytes))
+	} else {
+		fmt.Fprintf(w, "Bytes: %s to upload.\n", FormatBytes(s.Bytes))
+	}
+}
+
+// sizes describes the size of a file, and its compressed size if it is gzipped (and known).
+func (f *PlanFile) sizes() string {
+	if f.gzip() && f.UploadSize > 0 {
+		return fmt.Sprintf("%s, %s gzipped", FormatBytes(f.Size), FormatBytes(f.UploadSize))
+	}
+
+	return FormatBytes(f.Size)
+}
+
+// FormatBytes formats a byte count for display, e.g. 1.5 KiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Dump saves the plan to fname, as JSON.
+func (p *Plan) Dump(fname string) error {
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fname, append(buf, '\n'), 0o644) // #nosec G306 - plan file is not sensitive
+}
+
+// LoadPlan reads a plan saved with Dump. Unknown fields are rejected.
+func LoadPlan(fname string) (*Plan, error) {
+	buf, err := os.ReadFile(fname) // #nosec G304 - file path from user config is expected
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	p := &Plan{}
+	if err = dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("%s: unsupported plan version %d", fname, p.Version)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,122 @@
+package uploader
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPlan builds the plan for the test source folder against a cache where barbaz.txt
+// is up to date, foobar.html changed and gone.html was removed.
+func testPlan(t *testing.T, o Options) (p *Plan, current, old FileCache) {
+	t.Helper()
+
+	o.Source, o.Bucket = testSource, testBucket
+	current, err := scanSource(&o, FileCache{})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	old = FileCache{
+		"barbaz.txt":  &CacheEntry{Name: "barbaz.txt", MD5: current["barbaz.txt"].MD5, Key: "barbaz.txt"},
+		"foobar.html": &CacheEntry{Name: "foobar.html", MD5: "changed", Key: "foobar.html"},
+		"gone.html":   &CacheEntry{Name: "gone.html", MD5: "gone", Key: "gone.html"},
+	}
+	diff, hdrDiff := current.diff(old)
+
+	return newPlan(&o, current, old, diff, hdrDiff), current, old
+}
+
+func TestNewPlan(t *testing.T) {
+	p, _, _ := testPlan(t, Options{})
+	if len(p.Files) != 1 || p.Files[0].Action != ActionUpdate || p.Files[0].Name != "foobar.html" {
+		t.Fatalf("Expected only foobar.html to be updated, got %+v", p.Files)
+	}
+	if p.Files[0].Headers[ContentEncoding] != "gzip" || p.Files[0].Key != "foobar.html" {
+		t.Errorf("Expected the computed headers and key to be planned, got %+v", p.Files[0])
+	}
+
+	p, _, _ = testPlan(t, Options{Delete: true})
+	if len(p.Files) != 2 || p.Files[1].Action != ActionDelete || p.Files[1].Key != "gone.html" {
+		t.Fatalf("Expected gone.html to be deleted, got %+v", p.Files)
+	}
+	if s := p.Summary; s.Update != 1 || s.Delete != 1 || s.Create != 0 || s.Bytes != 8 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+}
+
+func TestPlanMeasure(t *testing.T) {
+	p, _, _ := testPlan(t, Options{})
+	if err := p.Measure(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	// gzip adds its own header, so tiny files get bigger.
+	if f := p.Files[0]; f.UploadSize == 0 || f.UploadSize == f.Size || p.Summary.UploadBytes != f.UploadSize {
+		t.Errorf("Expected the compressed size to be measured, got %+v %+v", f, p.Summary)
+	}
+
+	out := &bytes.Buffer{}
+	p.Print(out)
+	for _, s := range []string{"~ foobar.html -> foobar.html", "Content-Encoding: gzip", "Plan: 0 to create, 1 to update"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Expected the plan output to contain %q, got:\n%s", s, out)
+		}
+	}
+}
+
+func TestPlanDumpLoad(t *testing.T) {
+	p, _, _ := testPlan(t, Options{})
+	fname := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Dump(fname); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	loaded, err := LoadPlan(fname)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err = loaded.Matches(p); err != nil {
+		t.Error("Expected the loaded plan to match, got", err)
+	}
+}
+
+func TestPlanMatches(t *testing.T) {
+	p, current, old := testPlan(t, Options{})
+
+	old["foobar.html"].MD5 = current["foobar.html"].MD5
+	diff, hdrDiff := current.diff(old)
+	if err := p.Matches(newPlan(&Options{Source: testSource, Bucket: testBucket}, current, old, diff, hdrDiff)); err == nil {
+		t.Error("Expected the plan to be out of date")
+	}
+
+	p2, _, _ := testPlan(t, Options{Encrypt: true})
+	if err := p.Matches(p2); err == nil {
+		t.Error("Expected the plan to be out of date after turning encryption on")
+	}
+}
+
+func TestPlanFileMatches(t *testing.T) {
+	f := &PlanFile{Action: ActionUpdate, Name: "a.html", Key: "a.html", MD5: "1", Headers: Headers{CacheControl: "max-age=60"}}
+	tests := []struct {
+		change func(o *PlanFile)
+		want   string
+	}{
+		{func(*PlanFile) {}, ""},
+		{func(o *PlanFile) { o.Name = "b.html" }, "found b.html to update"},
+		{func(o *PlanFile) { o.Action = ActionCreate }, "planned to update, found create"},
+		{func(o *PlanFile) { o.Key = "x/a.html" }, `key changed from "a.html" to "x/a.html"`},
+		{func(o *PlanFile) { o.MD5 = "2" }, "content changed (md5 1, now 2)"},
+		{func(o *PlanFile) { o.Headers = Headers{CacheControl: "max-age=1"} }, "headers changed"},
+	}
+
+	for _, tc := range tests {
+		o := *f
+		tc.change(&o)
+		err := f.matches(&o)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("Expected %q, got %v", tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// releaseIndexName is the name of the release index object, under the releases prefix.
+const releaseIndexName = "index.json"
+
+// ErrReleaseConflict is returned when the release index was changed by someone else since we loaded it.
+var ErrReleaseConflict = errors.New("release index was modified by a concurrent run")
+
+// Release is a build uploaded under its own immutable prefix, see Releases.
+type Release struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Version is the version of the tool that uploaded the release.
+	Version string `json:"version,omitempty"`
+	// Keys lists the keys of the objects of the release, to delete them when it is pruned.
+	Keys []string `json:"keys"`
+}
+
+// ReleaseIndex lists the releases in a bucket, oldest first, and which one is live.
+type ReleaseIndex struct {
+	Current  string     `json:"current,omitempty"`
+	Releases []*Release `json:"releases"`
+}
+
+// Find returns the release with the given ID, nil if there is none.
+func (idx *ReleaseIndex) Find(id string) *Release {
+	for _, rel := range idx.Releases {
+		if rel.ID == id {
+			return rel
+		}
+	}
+
+	return nil
+}
+
+// Previous returns the release before the current one, nil if there is none.
+func (idx *ReleaseIndex) Previous() *Release {
+	i := slices.IndexFunc(idx.Releases, func(rel *Release) bool { return rel.ID == idx.Current })
+	if i <= 0 {
+		return nil
+	}
+
+	return idx.Releases[i-1]
+}
+
+// Releases manages the releases uploaded to a bucket: each one goes under its own prefix (e.g.
+// releases/<id>/) and is made live by copying its pointer files (e.g. index.html) to the root of
+// the bucket. The releases are listed in an index object under the prefix, which also records the
+// live one, so that it can serve as a manifest too.
+//
+// Like S3CacheStore, Releases only saves the index if it was not changed since it was loaded.
+type Releases struct {
+	// Encrypt turns server side encryption on for the pointers copied when promoting a release.
+	Encrypt bool
+
+	up             S3Uploader
+	bucket, prefix string
+	pointers       []string
+
+	etag *string // nil if the index did not exist when loaded
+}
+
+// NewReleases returns the releases under the prefix of the bucket, whose pointer files are pointers.
+func NewReleases(up S3Uploader, bucket, prefix string, pointers []string) *Releases {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &Releases{up: up, bucket: bucket, prefix: prefix, pointers: pointers}
+}
+
+// Prefix returns the prefix of the keys of the release with the given ID.
+func (r *Releases) Prefix(id string) string {
+	return r.prefix + id + "/"
+}
+
+// Load returns the release index, or an empty one if there is none yet.
+func (r *Releases) Load(ctx context.Context) (idx *ReleaseIndex, err error) {
+	idx, r.etag = &ReleaseIndex{Releases: []*Release{}}, nil
+
+	out, err := r.up.Download(ctx, &DownloadInput{Bucket: r.bucket, Key: r.prefix + releaseIndexName})
+	if errors.Is(err, ErrObjectNotFound) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := out.Body.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	buf, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, idx); err != nil {
+		return nil, fmt.Errorf("invalid release index: %w", err)
+	}
+	r.etag = out.ETag
+
+	return idx, nil
+}
+
+// save replaces the release index with idx. It fails with ErrReleaseConflict if the index was
+// changed since it was loaded.
+func (r *Releases) save(ctx context.Context, idx *ReleaseIndex) error {
+	buf, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	contentType, cacheControl := "application/json", "no-cache"
+	input := &UploadInput{Bucket: r.bucket, Key: r.prefix + releaseIndexName, Body: bytes.NewReader(buf),
+		ContentType: &contentType, CacheControl: &cacheControl}
+	if r.etag != nil {
+		input.IfMatch = r.etag
+	} else {
+		input.IfNoneMatch = stringPtr("*")
+	}
+
+	out, err := r.up.Upload(ctx, input)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return ErrReleaseConflict
+	} else if err != nil {
+		return err
+	}
+	r.etag = out.ETag
+
+	return nil
+}
+
+// Add records the release in the index.
+func (r *Releases) Add(ctx context.Context, rel *Release) error {
+	idx, err := r.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if idx.Find(rel.ID) != nil {
+		return fmt.Errorf("release %s already exists", rel.ID)
+	}
+	idx.Releases = append(idx.Releases, rel)
+
+	return r.save(ctx, idx)
+}
+
+// Promote makes the release with the given ID live, copying its pointer files over those at the
+// root of the bucket, and records it as the current one. It returns the keys of the pointers.
+func (r *Releases) Promote(ctx context.Context, id string) ([]string, error) {
+	idx, err := r.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Find(id) == nil {
+		return nil, fmt.Errorf("unknown release %q", id)
+	}
+
+	for _, p := range r.pointers {
+		in := &CopyInput{Bucket: r.bucket, Key: p, SourceKey: r.Prefix(id) + p}
+		if r.Encrypt {
+			in.ServerSideEncryption = &sse
+		}
+		_, err := r.up.Copy(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("copying %s of release %s: %w", p, id, err)
+		}
+	}
+	idx.Current = id
+
+	return r.pointers, r.save(ctx, idx)
+}
+
+// Rollback promotes the release before the current one, returning its ID and the keys of the
+// pointers.
+func (r *Releases) Rollback(ctx context.Context) (string, []string, error) {
+	idx, err := r.Load(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	prev := idx.Previous()
+	if prev == nil {
+		return "", nil, fmt.Errorf("no release before %q", idx.Current)
+	}
+
+	keys, err := r.Promote(ctx, prev.ID)
+	return prev.ID, keys, err
+}
+
+// Prune deletes all but the keep latest releases, and their objects, returning the IDs of those
+// deleted. The current release is always kept, and so is everything if keep is 0 or less.
+//
+// The index is saved first, so that it never lists a release whose objects are gone: nothing is
+// deleted if saving it fails (e.g. with ErrReleaseConflict), and the objects left behind if
+// deleting them fails are garbage, no longer part of any release.
+func (r *Releases) Prune(ctx context.Context, keep int) ([]string, error) {
+	idx, err := r.Load(ctx)
+	if err != nil || keep <= 0 || len(idx.Releases) <= keep {
+		return nil, err
+	}
+
+	var pruned []*Release
+	old, kept := idx.Releases[:len(idx.Releases)-keep], idx.Releases[len(idx.Releases)-keep:]
+	idx.Releases = []*Release{}
+	for _, rel := range old {
+		if rel.ID == idx.Current {
+			idx.Releases = append(idx.Releases, rel)
+			continue
+		}
+		pruned = append(pruned, rel)
+	}
+	idx.Releases = append(idx.Releases, kept...)
+	if err := r.save(ctx, idx); err != nil {
+		return nil, err
+	}
+
+	ids, errs := make([]string, 0, len(pruned)), []error{}
+	for _, rel := range pruned {
+		ids = append(ids, rel.ID)
+		for _, key := range rel.Keys {
+			if _, err := r.up.Delete(ctx, &DeleteInput{Bucket: r.bucket, Key: key}); err != nil {
+				errs = append(errs, fmt.Errorf("deleting %s of release %s: %w", key, rel.ID, err))
+			}
+		}
+	}
+
+	return ids, errors.Join(errs...)
+}
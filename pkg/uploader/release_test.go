@@ -0,0 +1,121 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReleases(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	r := NewReleases(mock, testBucket, "releases", []string{"index.html"})
+
+	for _, id := range []string{"1", "2", "3"} {
+		key := r.Prefix(id) + "index.html"
+		if _, err := mock.Upload(ctx, &UploadInput{Bucket: testBucket, Key: key, Body: strings.NewReader("release " + id)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Add(ctx, &Release{ID: id, Keys: []string{key}}); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if _, err := r.Promote(ctx, id); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if obj := mock.Objects[testBucket+"/index.html"]; obj == nil || string(obj.Content) != "release 3" {
+		t.Fatal("Expected the last release to be live, got", obj)
+	}
+	if err := r.Add(ctx, &Release{ID: "3"}); err == nil {
+		t.Error("Expected adding an existing release to fail")
+	}
+	if _, err := r.Promote(ctx, "4"); err == nil {
+		t.Error("Expected promoting an unknown release to fail")
+	}
+
+	id, keys, err := r.Rollback(ctx)
+	if err != nil || id != "2" || strings.Join(keys, ":") != "index.html" {
+		t.Fatalf("Expected to roll back to release 2, got %q %v %v", id, keys, err)
+	}
+	if obj := mock.Objects[testBucket+"/index.html"]; string(obj.Content) != "release 2" {
+		t.Error("Expected release 2 to be live, got", string(obj.Content))
+	}
+
+	// The current release is kept, even if it is not one of the latest.
+	pruned, err := r.Prune(ctx, 1)
+	if err != nil || strings.Join(pruned, ":") != "1" {
+		t.Fatalf("Expected release 1 to be pruned, got %v %v", pruned, err)
+	}
+	if mock.Objects[testBucket+"/releases/1/index.html"] != nil || mock.Objects[testBucket+"/releases/2/index.html"] == nil {
+		t.Error("Expected only the objects of release 1 to be deleted")
+	}
+	idx, err := r.Load(ctx)
+	if err != nil || idx.Current != "2" || len(idx.Releases) != 2 || idx.Find("3") == nil {
+		t.Errorf("Unexpected release index %+v %v", idx, err)
+	}
+}
+
+func TestReleasesConflict(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	r1, r2 := NewReleases(mock, testBucket, "releases/", nil), NewReleases(mock, testBucket, "releases/", nil)
+
+	idx, err := r1.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Add(ctx, &Release{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	idx.Releases = append(idx.Releases, &Release{ID: "2"})
+	if err := r1.save(ctx, idx); !errors.Is(err, ErrReleaseConflict) {
+		t.Error("Expected a conflict, got", err)
+	}
+}
+
+func TestReleasesPromoteEncrypted(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	r := NewReleases(mock, testBucket, "releases", []string{"index.html"})
+	r.Encrypt = true
+
+	if _, err := mock.Upload(ctx, &UploadInput{Bucket: testBucket, Key: r.Prefix("1") + "index.html", Body: strings.NewReader("release 1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(ctx, &Release{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Promote(ctx, "1"); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(mock.ObjectCopies) != 1 || mock.ObjectCopies[0].ServerSideEncryption == nil {
+		t.Error("Expected the pointer to be copied encrypted, got", mock.ObjectCopies)
+	}
+}
+
+func TestReleasesPruneSaveFails(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	r := NewReleases(mock, testBucket, "releases", nil)
+
+	for _, id := range []string{"1", "2"} {
+		key := r.Prefix(id) + "a.html"
+		if _, err := mock.Upload(ctx, &UploadInput{Bucket: testBucket, Key: key, Body: strings.NewReader(id)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Add(ctx, &Release{ID: id, Keys: []string{key}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The index is saved first, so nothing is deleted if that fails.
+	mock.ErrorFunc = func(input *UploadInput) error {
+		if strings.HasSuffix(input.Key, releaseIndexName) {
+			return errors.New("access denied")
+		}
+		return nil
+	}
+	if _, err := r.Prune(ctx, 1); err == nil {
+		t.Fatal("Expected pruning to fail")
+	}
+	if len(mock.Deletes) != 0 || mock.Objects[testBucket+"/releases/1/a.html"] == nil {
+		t.Error("Expected nothing to be deleted, got", len(mock.Deletes))
+	}
+}
@@ -0,0 +1,281 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Multipart upload defaults and limits, those of S3 and the SDK.
+const (
+	defaultPartSize        = 5 << 20
+	defaultPartConcurrency = 5
+	maxParts               = 10000
+)
+
+// uploadState is the state of a resumable upload, saved in Options.ResumeDir after each part. The
+// upload resumes only if the file, its headers and the part size are the same.
+type uploadState struct {
+	Bucket   string  `json:"bucket"`
+	Key      string  `json:"key"`
+	UploadID string  `json:"upload_id"`
+	MD5      string  `json:"md5"`
+	Size     int64   `json:"size"`
+	Headers  string  `json:"headers"`
+	PartSize int64   `json:"part_size"`
+	Parts    []*Part `json:"parts"`
+}
+
+// matches reports whether the upload in st is that of the same content as other.
+func (st *uploadState) matches(other *uploadState) bool {
+	return st.Bucket == other.Bucket && st.Key == other.Key && st.MD5 == other.MD5 && st.Size == other.Size &&
+		st.Headers == other.Headers && st.PartSize == other.PartSize
+}
+
+// keep keeps the parts recorded in st that S3 has too, with the same ETag and size.
+func (st *uploadState) keep(uploaded []*Part) {
+	st.Parts = slices.DeleteFunc(st.Parts, func(p *Part) bool {
+		return !slices.ContainsFunc(uploaded, func(u *Part) bool { return *u == *p })
+	})
+}
+
+// resumable reports whether src is uploaded with putResumable.
+func (s *Syncer) resumable(src *sourceFile) bool {
+	_, ok := s.up.(MultipartUploader)
+	return ok && s.opts.ResumeDir != "" && !src.gzip && src.md5 != "" && src.size > s.partSize(0)
+}
+
+// partSize returns the part size for a file of the given size.
+func (s *Syncer) partSize(size int64) int64 {
+	partSize := s.opts.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	return max(partSize, size/maxParts+1)
+}
+
+// statePath returns the file the state of the upload of src is saved in.
+func (s *Syncer) statePath(src *sourceFile) string {
+	sum := sha256.Sum256([]byte(s.opts.Bucket + "/" + src.key))
+	return filepath.Join(s.opts.ResumeDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// putResumable uploads src, opened as f, part by part, saving the parts uploaded so far, and resumes
+// the upload started by an earlier attempt, or run, if any. Only the parts S3 does not have are sent.
+func (s *Syncer) putResumable(ctx context.Context, src *sourceFile, f *os.File) (*UploadOutput, error) {
+	mu, path := s.up.(MultipartUploader), s.statePath(src)
+	want := &uploadState{Bucket: s.opts.Bucket, Key: src.key, MD5: src.md5, Size: src.size, Headers: src.headersHash(),
+		PartSize: s.partSize(src.size), Parts: []*Part{}}
+
+	st, err := s.resumeState(ctx, mu, path, want)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		id, err := mu.CreateMultipartUpload(ctx, src.uploadInput(s.opts.Bucket, nil))
+		if err != nil {
+			return nil, err
+		}
+		st = want
+		st.UploadID = id
+		if err := saveUploadState(path, st); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.uploadParts(ctx, mu, f, path, st); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(st.Parts, func(a, b *Part) int { return int(a.Number - b.Number) })
+	out, err := mu.CompleteMultipartUpload(ctx, &CompleteMultipartUploadInput{Bucket: st.Bucket, Key: st.Key,
+		UploadID: st.UploadID, Parts: st.Parts})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		s.log.Warn("Failed to remove the upload state", "object", src.key, "error", err)
+	}
+
+	return out, nil
+}
+
+// discardResumable aborts the resumable upload of src, given up on, and removes its state, unless
+// Options.LeavePartsOnError is set.
+func (s *Syncer) discardResumable(ctx context.Context, src *sourceFile) {
+	if s.opts.LeavePartsOnError || src.delete || src.headersOnly || !s.resumable(src) {
+		return
+	}
+
+	path := s.statePath(src)
+	st, err := loadUploadState(path)
+	if err != nil || st == nil {
+		return
+	}
+	abort := &AbortMultipartUploadInput{Bucket: st.Bucket, Key: st.Key, UploadID: st.UploadID}
+	if err := s.up.AbortMultipartUpload(ctx, abort); err != nil && !errors.Is(err, ErrUploadNotFound) {
+		s.log.Warn("Failed to abort", "object", st.Key, "upload_id", st.UploadID, "error", err)
+		return
+	}
+	s.log.Info("Aborted the upload", "object", st.Key, "upload_id", st.UploadID)
+	if err := os.Remove(path); err != nil {
+		s.log.Warn("Failed to remove the upload state", "object", st.Key, "error", err)
+	}
+}
+
+// resumeState returns the state of the upload to resume, with the parts S3 has, nil if there is none.
+// An upload of another version of the file is aborted.
+func (s *Syncer) resumeState(ctx context.Context, mu MultipartUploader, path string, want *uploadState) (*uploadState, error) {
+	st, err := loadUploadState(path)
+	if err != nil {
+		s.log.Warn("Ignoring the upload state", "object", want.Key, "error", err)
+		return nil, nil
+	} else if st == nil {
+		return nil, nil
+	}
+
+	if !st.matches(want) {
+		s.log.Info("Starting over the upload of a changed file", "object", want.Key, "upload_id", st.UploadID)
+		abort := &AbortMultipartUploadInput{Bucket: st.Bucket, Key: st.Key, UploadID: st.UploadID}
+		if err := s.up.AbortMultipartUpload(ctx, abort); err != nil && !errors.Is(err, ErrUploadNotFound) {
+			s.log.Warn("Failed to abort", "object", want.Key, "upload_id", st.UploadID, "error", err)
+		}
+		return nil, nil
+	}
+
+	parts, err := mu.ListParts(ctx, &ListPartsInput{Bucket: st.Bucket, Key: st.Key, UploadID: st.UploadID})
+	if errors.Is(err, ErrUploadNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	st.keep(parts)
+	s.log.Info("Resuming upload", "object", st.Key, "upload_id", st.UploadID, "parts", len(st.Parts))
+
+	return st, nil
+}
+
+// uploadParts uploads the parts of f missing from st, PartConcurrency at a time, adding them to st and
+// saving it to path as they complete. It stops at the first failure.
+func (s *Syncer) uploadParts(ctx context.Context, mu MultipartUploader, f *os.File, path string, st *uploadState) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var missing []int32
+	for n := int32(1); int64(n-1)*st.PartSize < st.Size; n++ {
+		if !slices.ContainsFunc(st.Parts, func(p *Part) bool { return p.Number == n }) {
+			missing = append(missing, n)
+		}
+	}
+
+	numbers := make(chan int32)
+	go func() {
+		defer close(numbers)
+		for _, n := range missing {
+			select {
+			case numbers <- n:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		partErr error
+	)
+	for range s.partConcurrency() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				part, err := s.uploadPart(ctx, mu, f, st, n)
+
+				lock.Lock()
+				if err == nil {
+					st.Parts = append(st.Parts, part)
+					err = saveUploadState(path, st)
+				}
+				if err != nil && partErr == nil {
+					partErr = err
+					cancel()
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return partErr
+}
+
+// uploadPart uploads the part number n of f.
+func (s *Syncer) uploadPart(ctx context.Context, mu MultipartUploader, f *os.File, st *uploadState, n int32) (*Part, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	off := int64(n-1) * st.PartSize
+	size := min(st.PartSize, st.Size-off)
+	etag, err := mu.UploadPart(ctx, &UploadPartInput{Bucket: st.Bucket, Key: st.Key, UploadID: st.UploadID, Number: n,
+		Body: io.NewSectionReader(f, off, size), Size: size})
+	if err != nil {
+		return nil, fmt.Errorf("part %d: %w", n, err)
+	}
+	s.log.Debug("Uploaded part", "object", st.Key, "part", n, "bytes", size)
+
+	return &Part{Number: n, ETag: etag, Size: size}, nil
+}
+
+// partConcurrency returns the number of parts uploaded at once.
+func (s *Syncer) partConcurrency() int {
+	if s.opts.PartConcurrency <= 0 {
+		return defaultPartConcurrency
+	}
+
+	return s.opts.PartConcurrency
+}
+
+// loadUploadState loads the upload state saved at path, nil if there is none.
+func loadUploadState(path string) (*uploadState, error) {
+	buf, err := os.ReadFile(path) // #nosec G304 - user given cache folder
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	st := &uploadState{}
+	if err := json.Unmarshal(buf, st); err != nil {
+		return nil, fmt.Errorf("invalid upload state %s: %w", path, err)
+	}
+
+	return st, nil
+}
+
+// saveUploadState saves st to path, through a temporary file so that a crash never leaves it truncated.
+func saveUploadState(path string, st *uploadState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
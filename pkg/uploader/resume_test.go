@@ -0,0 +1,114 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncerResumeUpload(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	src, resumeDir := t.TempDir(), t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 160) // 2.5 parts
+	if err := os.WriteFile(filepath.Join(src, "video.mp4"), content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSyncer(t, Options{Source: src, ResumeDir: resumeDir, PartSize: 1024, PartConcurrency: 1, LeavePartsOnError: true}, mock)
+
+	// The upload fails at its last part, the first two are kept.
+	mock.PartErrorFunc = func(input *UploadPartInput) error {
+		if input.Number == 3 {
+			return errors.New("AccessDenied")
+		}
+		return nil
+	}
+	res, err := s.Run(ctx)
+	if err != nil || len(res.Rejected) != 1 {
+		t.Fatalf("Expected the upload to fail, got %+v %v", res, err)
+	}
+	if states, _ := os.ReadDir(resumeDir); len(states) != 1 {
+		t.Fatalf("Expected the upload state to be saved, got %d files", len(states))
+	}
+
+	// The next run only sends the missing part.
+	mock.PartErrorFunc, mock.PartUploads = nil, nil
+	res, err = s.Run(ctx)
+	if err != nil || len(res.Uploaded) != 1 {
+		t.Fatalf("Expected the upload to succeed, got %+v %v", res, err)
+	}
+	if len(mock.PartUploads) != 1 || mock.PartUploads[0].Number != 3 || mock.PartUploads[0].Size != 512 {
+		t.Errorf("Expected only the last part to be sent, got %d parts", len(mock.PartUploads))
+	}
+	if obj := mock.Objects[testBucket+"/video.mp4"]; obj == nil || !bytes.Equal(obj.Content, content) {
+		t.Error("Expected the object to be assembled from the parts")
+	}
+	if states, _ := os.ReadDir(resumeDir); len(states) != 0 || len(mock.MultipartUploads[testBucket]) != 0 {
+		t.Errorf("Expected the upload to be cleaned up, got %d states", len(states))
+	}
+}
+
+func TestSyncerResumeAbortOnError(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	src, resumeDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "video.mp4"), bytes.Repeat([]byte("a"), 3000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSyncer(t, Options{Source: src, ResumeDir: resumeDir, PartSize: 1024, PartConcurrency: 1}, mock)
+
+	// Without LeavePartsOnError, the upload of a file that failed for good is aborted.
+	mock.PartErrorFunc = func(input *UploadPartInput) error {
+		if input.Number == 2 {
+			return errors.New("AccessDenied")
+		}
+		return nil
+	}
+	if res, err := s.Run(ctx); err != nil || len(res.Rejected) != 1 {
+		t.Fatalf("Expected the upload to fail, got %+v %v", res, err)
+	}
+	if len(mock.Aborts) != 1 || len(mock.MultipartUploads[testBucket]) != 0 {
+		t.Errorf("Expected the upload to be aborted, got %d aborts", len(mock.Aborts))
+	}
+	if states, _ := os.ReadDir(resumeDir); len(states) != 0 {
+		t.Errorf("Expected the upload state to be removed, got %d files", len(states))
+	}
+}
+
+func TestSyncerResumeChangedFile(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	src := t.TempDir()
+	fpath := filepath.Join(src, "video.mp4")
+	if err := os.WriteFile(fpath, bytes.Repeat([]byte("a"), 3000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSyncer(t, Options{Source: src, ResumeDir: t.TempDir(), PartSize: 1024, Paranoid: true, LeavePartsOnError: true}, mock)
+
+	mock.PartErrorFunc = func(input *UploadPartInput) error {
+		if input.Number == 2 {
+			return errors.New("AccessDenied")
+		}
+		return nil
+	}
+	if res, err := s.Run(ctx); err != nil || len(res.Rejected) != 1 {
+		t.Fatalf("Expected the upload to fail, got %+v %v", res, err)
+	}
+
+	// The file changed, so its upload starts over, the old one is aborted.
+	changed := bytes.Repeat([]byte("b"), 3000)
+	if err := os.WriteFile(fpath, changed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mock.PartErrorFunc, mock.PartUploads = nil, nil
+	if res, err := s.Run(ctx); err != nil || len(res.Uploaded) != 1 {
+		t.Fatalf("Expected the upload to succeed, got %+v %v", res, err)
+	}
+	if len(mock.Aborts) != 1 || len(mock.PartUploads) != 3 {
+		t.Errorf("Expected the old upload to be aborted and all the parts sent, got %d aborts and %d parts",
+			len(mock.Aborts), len(mock.PartUploads))
+	}
+	if obj := mock.Objects[testBucket+"/video.mp4"]; obj == nil || !bytes.Equal(obj.Content, changed) {
+		t.Error("Expected the object to have the new content")
+	}
+}
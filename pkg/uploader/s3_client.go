@@ -0,0 +1,491 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Uploader abstracts S3 upload operations for testability.
+// Implementations include the real AWS SDK v2 uploader and mock versions for testing.
+type S3Uploader interface {
+	// Upload uploads content to S3 with the specified parameters.
+	Upload(ctx context.Context, input *UploadInput) (*UploadOutput, error)
+
+	// CopyMetadata replaces the headers of an existing object, without re-sending its content.
+	// The input Body is ignored.
+	CopyMetadata(ctx context.Context, input *UploadInput) (*UploadOutput, error)
+
+	// Copy copies an object (or a given version of it) to another key of the same bucket, server side,
+	// with its headers.
+	Copy(ctx context.Context, input *CopyInput) (*UploadOutput, error)
+
+	// Download fetches an object from S3. It returns ErrObjectNotFound if the object does not exist.
+	Download(ctx context.Context, input *DownloadInput) (*DownloadOutput, error)
+
+	// Delete removes an object from S3. Deleting an object that does not exist is not an error.
+	Delete(ctx context.Context, input *DeleteInput) (*DeleteOutput, error)
+
+	// ListMultipartUploads lists the multipart uploads in progress (or abandoned) under a prefix of a bucket.
+	ListMultipartUploads(ctx context.Context, input *ListMultipartUploadsInput) ([]*MultipartUpload, error)
+
+	// AbortMultipartUpload aborts a multipart upload, deleting the parts uploaded so far.
+	AbortMultipartUpload(ctx context.Context, input *AbortMultipartUploadInput) error
+}
+
+// ObjectVersioner is implemented by the S3Uploaders that can tell the current version of an object,
+// used to record the version each change replaces, for the run to be rolled back (see RunFile).
+type ObjectVersioner interface {
+	// CurrentVersion returns the ID of the current version of the object, empty if the bucket is
+	// not versioned. It returns ErrObjectNotFound if there is no such object, or if its current
+	// version is a delete marker.
+	CurrentVersion(ctx context.Context, input *HeadInput) (string, error)
+}
+
+// MultipartUploader is implemented by the S3Uploaders that expose the steps of multipart uploads,
+// used to upload large files resumably (see Options.ResumeDir).
+type MultipartUploader interface {
+	// CreateMultipartUpload starts a multipart upload with the headers of input, whose Body is
+	// ignored, and returns its ID.
+	CreateMultipartUpload(ctx context.Context, input *UploadInput) (string, error)
+
+	// UploadPart uploads a part of a multipart upload and returns its ETag.
+	UploadPart(ctx context.Context, input *UploadPartInput) (string, error)
+
+	// ListParts lists the parts uploaded so far. It returns ErrUploadNotFound if the upload was
+	// completed or aborted meanwhile.
+	ListParts(ctx context.Context, input *ListPartsInput) ([]*Part, error)
+
+	// CompleteMultipartUpload assembles the given parts into the object.
+	CompleteMultipartUpload(ctx context.Context, input *CompleteMultipartUploadInput) (*UploadOutput, error)
+}
+
+// Errors returned by S3Uploader implementations.
+var (
+	// ErrObjectNotFound is returned when the requested object does not exist.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned when a conditional write (IfMatch/IfNoneMatch) fails.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrUploadNotFound is returned when the multipart upload does not exist (anymore).
+	ErrUploadNotFound = errors.New("multipart upload not found")
+)
+
+// UploadInput contains the parameters for an S3 upload operation.
+// This abstraction allows tests to verify upload parameters without
+// depending directly on AWS SDK types.
+type UploadInput struct {
+	Bucket               string
+	Key                  string
+	Body                 io.Reader
+	ContentType          *string
+	ContentEncoding      *string
+	CacheControl         *string
+	ServerSideEncryption *string
+
+	// Size is the length of Body, or an upper bound of it (e.g. for a body gzipped on the fly), 0 if
+	// unknown. Multipart uploads use it to pick parts large enough for the whole body to fit in the
+	// 10,000 parts S3 allows.
+	Size int64
+
+	// Optional conditions: only write if the current object has the given ETag (IfMatch),
+	// or only write if there is no object at all (IfNoneMatch="*").
+	IfMatch     *string
+	IfNoneMatch *string
+}
+
+// UploadOutput contains the result of an S3 upload operation.
+type UploadOutput struct {
+	Location  string
+	VersionID *string
+	ETag      *string
+}
+
+// CopyInput contains the parameters for an S3 copy operation.
+type CopyInput struct {
+	Bucket    string
+	Key       string
+	SourceKey string
+	// SourceVersionID selects the version to copy, on versioned buckets. The current one if nil.
+	SourceVersionID *string
+	// ServerSideEncryption encrypts the copy, which is not encrypted otherwise, whatever the source.
+	ServerSideEncryption *string
+}
+
+// DownloadInput contains the parameters for an S3 download operation.
+type DownloadInput struct {
+	Bucket string
+	Key    string
+}
+
+// HeadInput contains the parameters for looking up an object, without downloading it.
+type HeadInput struct {
+	Bucket string
+	Key    string
+}
+
+// DownloadOutput contains the result of an S3 download operation.
+// The caller is responsible for closing the Body.
+type DownloadOutput struct {
+	Body io.ReadCloser
+	ETag *string
+}
+
+// DeleteInput contains the parameters for an S3 delete operation.
+type DeleteInput struct {
+	Bucket string
+	Key    string
+}
+
+// DeleteOutput contains the result of an S3 delete operation.
+type DeleteOutput struct {
+	// VersionID is the version of the delete marker, on versioned buckets.
+	VersionID *string
+}
+
+// ListMultipartUploadsInput contains the parameters for listing multipart uploads.
+type ListMultipartUploadsInput struct {
+	Bucket string
+	Prefix string
+}
+
+// MultipartUpload is a multipart upload in progress, or abandoned.
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// AbortMultipartUploadInput contains the parameters for aborting a multipart upload.
+type AbortMultipartUploadInput struct {
+	Bucket   string
+	Key      string
+	UploadID string
+}
+
+// Part is a part of a multipart upload.
+type Part struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// UploadPartInput contains the parameters for uploading a part of a multipart upload.
+type UploadPartInput struct {
+	Bucket   string
+	Key      string
+	UploadID string
+	Number   int32
+	Body     io.ReadSeeker
+	Size     int64
+}
+
+// ListPartsInput contains the parameters for listing the parts of a multipart upload.
+type ListPartsInput struct {
+	Bucket   string
+	Key      string
+	UploadID string
+}
+
+// CompleteMultipartUploadInput contains the parameters for completing a multipart upload.
+type CompleteMultipartUploadInput struct {
+	Bucket   string
+	Key      string
+	UploadID string
+	Parts    []*Part
+}
+
+// S3UploaderSDK implements S3Uploader using the AWS SDK v2.
+type S3UploaderSDK struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+}
+
+// NewS3Uploader creates a new S3Uploader backed by the AWS SDK v2.
+func NewS3Uploader(cfg *aws.Config, optFns ...func(*manager.Uploader)) *S3UploaderSDK {
+	client := s3.NewFromConfig(*cfg)
+	uploader := manager.NewUploader(client, optFns...)
+	return &S3UploaderSDK{client: client, uploader: uploader}
+}
+
+// NewS3UploaderWithClient creates a new S3Uploader with a custom S3 client.
+// Useful for testing with custom endpoints (e.g., LocalStack).
+func NewS3UploaderWithClient(client *s3.Client, optFns ...func(*manager.Uploader)) *S3UploaderSDK {
+	uploader := manager.NewUploader(client, optFns...)
+	return &S3UploaderSDK{client: client, uploader: uploader}
+}
+
+// Upload implements S3Uploader.Upload using the AWS SDK v2 manager.
+func (u *S3UploaderSDK) Upload(ctx context.Context, input *UploadInput) (*UploadOutput, error) {
+	sdkInput := &s3.PutObjectInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+		Body:   input.Body,
+	}
+
+	// Only set optional fields if they are provided
+	if input.ContentType != nil {
+		sdkInput.ContentType = input.ContentType
+	}
+	if input.ContentEncoding != nil {
+		sdkInput.ContentEncoding = input.ContentEncoding
+	}
+	if input.CacheControl != nil {
+		sdkInput.CacheControl = input.CacheControl
+	}
+	if input.ServerSideEncryption != nil {
+		sdkInput.ServerSideEncryption = types.ServerSideEncryption(*input.ServerSideEncryption)
+	}
+	sdkInput.IfMatch = input.IfMatch
+	sdkInput.IfNoneMatch = input.IfNoneMatch
+
+	result, err := u.uploader.Upload(ctx, sdkInput, func(up *manager.Uploader) {
+		if size := input.Size/int64(manager.MaxUploadParts) + 1; up.PartSize < size {
+			up.PartSize = size
+		}
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &UploadOutput{
+		Location:  result.Location,
+		VersionID: result.VersionID,
+		ETag:      result.ETag,
+	}, nil
+}
+
+// CopyMetadata implements S3Uploader.CopyMetadata by copying the object over itself
+// with MetadataDirective=REPLACE.
+func (u *S3UploaderSDK) CopyMetadata(ctx context.Context, input *UploadInput) (*UploadOutput, error) {
+	sdkInput := &s3.CopyObjectInput{
+		Bucket:            aws.String(input.Bucket),
+		Key:               aws.String(input.Key),
+		CopySource:        aws.String(url.PathEscape(input.Bucket + "/" + input.Key)),
+		MetadataDirective: types.MetadataDirectiveReplace,
+		ContentType:       input.ContentType,
+		ContentEncoding:   input.ContentEncoding,
+		CacheControl:      input.CacheControl,
+	}
+	if input.ServerSideEncryption != nil {
+		sdkInput.ServerSideEncryption = types.ServerSideEncryption(*input.ServerSideEncryption)
+	}
+
+	result, err := u.client.CopyObject(ctx, sdkInput)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	out := &UploadOutput{VersionID: result.VersionId}
+	if result.CopyObjectResult != nil {
+		out.ETag = result.CopyObjectResult.ETag
+	}
+
+	return out, nil
+}
+
+// Download implements S3Uploader.Download using GetObject.
+func (u *S3UploaderSDK) Download(ctx context.Context, input *DownloadInput) (*DownloadOutput, error) {
+	result, err := u.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &DownloadOutput{Body: result.Body, ETag: result.ETag}, nil
+}
+
+// CurrentVersion implements ObjectVersioner.CurrentVersion using HeadObject.
+func (u *S3UploaderSDK) CurrentVersion(ctx context.Context, input *HeadInput) (string, error) {
+	result, err := u.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+	})
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	return aws.ToString(result.VersionId), nil
+}
+
+// Copy implements S3Uploader.Copy.
+func (u *S3UploaderSDK) Copy(ctx context.Context, input *CopyInput) (*UploadOutput, error) {
+	source := url.PathEscape(input.Bucket + "/" + input.SourceKey)
+	if input.SourceVersionID != nil {
+		source += "?versionId=" + url.QueryEscape(*input.SourceVersionID)
+	}
+
+	sdkInput := &s3.CopyObjectInput{
+		Bucket:     aws.String(input.Bucket),
+		Key:        aws.String(input.Key),
+		CopySource: aws.String(source),
+	}
+	if input.ServerSideEncryption != nil {
+		sdkInput.ServerSideEncryption = types.ServerSideEncryption(*input.ServerSideEncryption)
+	}
+
+	result, err := u.client.CopyObject(ctx, sdkInput)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	out := &UploadOutput{VersionID: result.VersionId}
+	if result.CopyObjectResult != nil {
+		out.ETag = result.CopyObjectResult.ETag
+	}
+
+	return out, nil
+}
+
+// Delete implements S3Uploader.Delete using DeleteObject.
+func (u *S3UploaderSDK) Delete(ctx context.Context, input *DeleteInput) (*DeleteOutput, error) {
+	result, err := u.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(input.Bucket),
+		Key:    aws.String(input.Key),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &DeleteOutput{VersionID: result.VersionId}, nil
+}
+
+// ListMultipartUploads implements S3Uploader.ListMultipartUploads, going through all the pages.
+func (u *S3UploaderSDK) ListMultipartUploads(ctx context.Context, input *ListMultipartUploadsInput) ([]*MultipartUpload, error) {
+	var uploads []*MultipartUpload
+	sdkInput := &s3.ListMultipartUploadsInput{Bucket: aws.String(input.Bucket), Prefix: aws.String(input.Prefix)}
+	for {
+		page, err := u.client.ListMultipartUploads(ctx, sdkInput)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, mu := range page.Uploads {
+			uploads = append(uploads, &MultipartUpload{Key: aws.ToString(mu.Key), UploadID: aws.ToString(mu.UploadId),
+				Initiated: aws.ToTime(mu.Initiated)})
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			return uploads, nil
+		}
+		sdkInput.KeyMarker, sdkInput.UploadIdMarker = page.NextKeyMarker, page.NextUploadIdMarker
+	}
+}
+
+// AbortMultipartUpload implements S3Uploader.AbortMultipartUpload.
+func (u *S3UploaderSDK) AbortMultipartUpload(ctx context.Context, input *AbortMultipartUploadInput) error {
+	_, err := u.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(input.Bucket),
+		Key:      aws.String(input.Key),
+		UploadId: aws.String(input.UploadID),
+	})
+
+	return translateError(err)
+}
+
+// CreateMultipartUpload implements MultipartUploader.CreateMultipartUpload.
+func (u *S3UploaderSDK) CreateMultipartUpload(ctx context.Context, input *UploadInput) (string, error) {
+	sdkInput := &s3.CreateMultipartUploadInput{
+		Bucket:          aws.String(input.Bucket),
+		Key:             aws.String(input.Key),
+		ContentType:     input.ContentType,
+		ContentEncoding: input.ContentEncoding,
+		CacheControl:    input.CacheControl,
+	}
+	if input.ServerSideEncryption != nil {
+		sdkInput.ServerSideEncryption = types.ServerSideEncryption(*input.ServerSideEncryption)
+	}
+
+	result, err := u.client.CreateMultipartUpload(ctx, sdkInput)
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	return aws.ToString(result.UploadId), nil
+}
+
+// UploadPart implements MultipartUploader.UploadPart.
+func (u *S3UploaderSDK) UploadPart(ctx context.Context, input *UploadPartInput) (string, error) {
+	result, err := u.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(input.Bucket),
+		Key:           aws.String(input.Key),
+		UploadId:      aws.String(input.UploadID),
+		PartNumber:    aws.Int32(input.Number),
+		Body:          input.Body,
+		ContentLength: aws.Int64(input.Size),
+	})
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	return aws.ToString(result.ETag), nil
+}
+
+// ListParts implements MultipartUploader.ListParts, going through all the pages.
+func (u *S3UploaderSDK) ListParts(ctx context.Context, input *ListPartsInput) ([]*Part, error) {
+	var parts []*Part
+	p := s3.NewListPartsPaginator(u.client, &s3.ListPartsInput{
+		Bucket:   aws.String(input.Bucket),
+		Key:      aws.String(input.Key),
+		UploadId: aws.String(input.UploadID),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, &Part{Number: aws.ToInt32(part.PartNumber), ETag: aws.ToString(part.ETag), Size: aws.ToInt64(part.Size)})
+		}
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload implements MultipartUploader.CompleteMultipartUpload.
+func (u *S3UploaderSDK) CompleteMultipartUpload(ctx context.Context, input *CompleteMultipartUploadInput) (*UploadOutput, error) {
+	parts := make([]types.CompletedPart, 0, len(input.Parts))
+	for _, part := range input.Parts {
+		parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(part.Number), ETag: aws.String(part.ETag)})
+	}
+
+	result, err := u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(input.Bucket),
+		Key:             aws.String(input.Key),
+		UploadId:        aws.String(input.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &UploadOutput{Location: aws.ToString(result.Location), VersionID: result.VersionId, ETag: result.ETag}, nil
+}
+
+// translateError maps the S3 errors we act upon to our own sentinel errors,
+// keeping the original error in the chain.
+func translateError(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.ErrorCode() {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %w", ErrObjectNotFound, err)
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	case "NoSuchUpload":
+		return fmt.Errorf("%w: %w", ErrUploadNotFound, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,545 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockS3Uploader is a test double for S3Uploader that records all upload
+// attempts and can be configured to return specific errors.
+type MockS3Uploader struct {
+	mu sync.Mutex
+
+	// Uploads records all upload attempts in order
+	Uploads []*RecordedUpload
+
+	// ErrorFunc allows dynamic error injection based on the upload input.
+	// If nil, uploads succeed. Return an error to simulate failures.
+	ErrorFunc func(input *UploadInput) error
+
+	// UploadCount tracks the total number of upload attempts
+	UploadCount int
+
+	// Copies records all metadata copy attempts in order
+	Copies []*RecordedUpload
+
+	// Deletes records all delete attempts in order
+	Deletes []*DeleteInput
+
+	// ObjectCopies records all copy attempts in order
+	ObjectCopies []*CopyInput
+
+	// Objects holds the content of the successfully uploaded objects, keyed by "bucket/key".
+	// Tests can also seed it to simulate pre-existing objects.
+	Objects map[string]*MockObject
+
+	// Versioned simulates a versioned bucket: every write gets a version ID ("v1", "v2"...),
+	// and Versions keeps all of them, keyed by version ID.
+	Versioned bool
+	Versions  map[string]*MockObject
+	// HeadErrorFunc allows failing CurrentVersion.
+	HeadErrorFunc func(input *HeadInput) error
+
+	// MultipartUploads holds the multipart uploads in progress, keyed by bucket. Tests seed it.
+	MultipartUploads map[string][]*MultipartUpload
+
+	// Aborts records all multipart upload abort attempts in order
+	Aborts []*AbortMultipartUploadInput
+
+	// PartUploads records all part upload attempts in order, and PartErrorFunc allows failing some.
+	PartUploads   []*UploadPartInput
+	PartErrorFunc func(input *UploadPartInput) error
+	// parts holds the content of the parts uploaded, keyed by upload ID, then part number.
+	parts map[string]map[int32][]byte
+}
+
+// MockObject is an object stored by MockS3Uploader.
+type MockObject struct {
+	Content   []byte
+	ETag      string
+	VersionID string
+}
+
+// newVersion gives obj a version ID if the bucket is versioned, and records it in m.Versions.
+// It returns the version ID, nil if the bucket is not versioned.
+func (m *MockS3Uploader) newVersion(obj *MockObject) *string {
+	if !m.Versioned {
+		return nil
+	}
+	if m.Versions == nil {
+		m.Versions = map[string]*MockObject{}
+	}
+	obj.VersionID = fmt.Sprintf("v%d", len(m.Versions)+1)
+	m.Versions[obj.VersionID] = obj
+
+	return stringPtr(obj.VersionID)
+}
+
+// RecordedUpload stores the details of an upload attempt for verification.
+type RecordedUpload struct {
+	Input   *UploadInput
+	Content []byte // Body content is read and stored for verification
+	Error   error  // The error returned (if any)
+}
+
+// NewMockS3Uploader creates a new mock uploader that records uploads.
+func NewMockS3Uploader() *MockS3Uploader {
+	return &MockS3Uploader{
+		Uploads: make([]*RecordedUpload, 0),
+		Objects: map[string]*MockObject{},
+	}
+}
+
+// Upload implements S3Uploader.Upload by recording the upload and
+// optionally returning an error from ErrorFunc.
+func (m *MockS3Uploader) Upload(_ context.Context, input *UploadInput) (*UploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.UploadCount++
+
+	// Read the body content for verification
+	var content []byte
+	if input.Body != nil {
+		var err error
+		content, err = io.ReadAll(input.Body)
+		if err != nil {
+			return nil, fmt.Errorf("mock: failed to read body: %w", err)
+		}
+	}
+
+	recorded := &RecordedUpload{
+		Input:   input,
+		Content: content,
+	}
+
+	// Check if we should return an error
+	var err error
+	if m.ErrorFunc != nil {
+		err = m.ErrorFunc(input)
+	}
+	if err == nil {
+		err = m.checkConditions(input)
+	}
+	recorded.Error = err
+
+	m.Uploads = append(m.Uploads, recorded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &MockObject{Content: content, ETag: fmt.Sprintf("\"%x\"", md5.Sum(content))} // #nosec G401
+	if m.Objects == nil {
+		m.Objects = map[string]*MockObject{}
+	}
+	m.Objects[input.Bucket+"/"+input.Key] = obj
+
+	return &UploadOutput{
+		Location:  fmt.Sprintf("https://%s.s3.amazonaws.com/%s", input.Bucket, input.Key),
+		ETag:      stringPtr(obj.ETag),
+		VersionID: m.newVersion(obj),
+	}, nil
+}
+
+// checkConditions verifies the IfMatch/IfNoneMatch conditions against the stored objects.
+func (m *MockS3Uploader) checkConditions(input *UploadInput) error {
+	obj := m.Objects[input.Bucket+"/"+input.Key]
+	if input.IfNoneMatch != nil && *input.IfNoneMatch == "*" && obj != nil {
+		return ErrPreconditionFailed
+	}
+	if input.IfMatch != nil && (obj == nil || obj.ETag != *input.IfMatch) {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// Download implements S3Uploader.Download by returning a previously uploaded (or seeded) object.
+func (m *MockS3Uploader) Download(_ context.Context, input *DownloadInput) (*DownloadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj := m.Objects[input.Bucket+"/"+input.Key]
+	if obj == nil {
+		return nil, ErrObjectNotFound
+	}
+
+	return &DownloadOutput{Body: io.NopCloser(bytes.NewReader(obj.Content)), ETag: stringPtr(obj.ETag)}, nil
+}
+
+// CurrentVersion implements ObjectVersioner.CurrentVersion, returning the version ID of the stored
+// object, or ErrObjectNotFound. HeadErrorFunc allows failing it.
+func (m *MockS3Uploader) CurrentVersion(_ context.Context, input *HeadInput) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.HeadErrorFunc != nil {
+		if err := m.HeadErrorFunc(input); err != nil {
+			return "", err
+		}
+	}
+	obj := m.Objects[input.Bucket+"/"+input.Key]
+	if obj == nil {
+		return "", ErrObjectNotFound
+	}
+
+	return obj.VersionID, nil
+}
+
+// CopyMetadata implements S3Uploader.CopyMetadata by recording the copy and
+// optionally returning an error from ErrorFunc.
+func (m *MockS3Uploader) CopyMetadata(_ context.Context, input *UploadInput) (*UploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorded := &RecordedUpload{Input: input}
+
+	var err error
+	if m.ErrorFunc != nil {
+		err = m.ErrorFunc(input)
+		recorded.Error = err
+	}
+
+	m.Copies = append(m.Copies, recorded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	etag := "\"mock-etag\""
+	if obj := m.Objects[input.Bucket+"/"+input.Key]; obj != nil {
+		etag = obj.ETag
+	}
+
+	return &UploadOutput{ETag: stringPtr(etag)}, nil
+}
+
+// Copy implements S3Uploader.Copy by recording the copy and duplicating the stored object.
+// It returns ErrObjectNotFound if there is no source object, or an error from ErrorFunc
+// (called with the destination key).
+func (m *MockS3Uploader) Copy(_ context.Context, input *CopyInput) (*UploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ObjectCopies = append(m.ObjectCopies, input)
+	if m.ErrorFunc != nil {
+		if err := m.ErrorFunc(&UploadInput{Bucket: input.Bucket, Key: input.Key}); err != nil {
+			return nil, err
+		}
+	}
+
+	obj := m.Objects[input.Bucket+"/"+input.SourceKey]
+	if input.SourceVersionID != nil {
+		obj = m.Versions[*input.SourceVersionID]
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, input.SourceKey)
+	}
+	cp := &MockObject{Content: obj.Content, ETag: obj.ETag}
+	m.Objects[input.Bucket+"/"+input.Key] = cp
+
+	return &UploadOutput{ETag: stringPtr(obj.ETag), VersionID: m.newVersion(cp)}, nil
+}
+
+// Delete implements S3Uploader.Delete by recording the delete and removing the stored object.
+// ErrorFunc is consulted with an UploadInput holding the bucket and key.
+func (m *MockS3Uploader) Delete(_ context.Context, input *DeleteInput) (*DeleteOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Deletes = append(m.Deletes, input)
+	if m.ErrorFunc != nil {
+		if err := m.ErrorFunc(&UploadInput{Bucket: input.Bucket, Key: input.Key}); err != nil {
+			return nil, err
+		}
+	}
+	delete(m.Objects, input.Bucket+"/"+input.Key)
+
+	return &DeleteOutput{VersionID: m.newVersion(&MockObject{})}, nil
+}
+
+// ListMultipartUploads implements S3Uploader.ListMultipartUploads, returning the uploads of
+// MultipartUploads under the prefix.
+func (m *MockS3Uploader) ListMultipartUploads(_ context.Context, input *ListMultipartUploadsInput) ([]*MultipartUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var uploads []*MultipartUpload
+	for _, mu := range m.MultipartUploads[input.Bucket] {
+		if strings.HasPrefix(mu.Key, input.Prefix) {
+			uploads = append(uploads, mu)
+		}
+	}
+
+	return uploads, nil
+}
+
+// AbortMultipartUpload implements S3Uploader.AbortMultipartUpload by recording the abort and
+// removing the upload from MultipartUploads. ErrorFunc is consulted with an UploadInput holding
+// the bucket and key.
+func (m *MockS3Uploader) AbortMultipartUpload(_ context.Context, input *AbortMultipartUploadInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Aborts = append(m.Aborts, input)
+	if m.ErrorFunc != nil {
+		if err := m.ErrorFunc(&UploadInput{Bucket: input.Bucket, Key: input.Key}); err != nil {
+			return err
+		}
+	}
+	if uploads, ok := m.MultipartUploads[input.Bucket]; ok {
+		m.MultipartUploads[input.Bucket] = slices.DeleteFunc(uploads, func(mu *MultipartUpload) bool {
+			return mu.UploadID == input.UploadID
+		})
+	}
+
+	return nil
+}
+
+// CreateMultipartUpload implements MultipartUploader.CreateMultipartUpload by adding an upload to
+// MultipartUploads. ErrorFunc is consulted with the input.
+func (m *MockS3Uploader) CreateMultipartUpload(_ context.Context, input *UploadInput) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ErrorFunc != nil {
+		if err := m.ErrorFunc(input); err != nil {
+			return "", err
+		}
+	}
+	if m.MultipartUploads == nil {
+		m.MultipartUploads = map[string][]*MultipartUpload{}
+	}
+	if m.parts == nil {
+		m.parts = map[string]map[int32][]byte{}
+	}
+	id := fmt.Sprintf("u%d", len(m.parts)+1)
+	m.parts[id] = map[int32][]byte{}
+	m.MultipartUploads[input.Bucket] = append(m.MultipartUploads[input.Bucket],
+		&MultipartUpload{Key: input.Key, UploadID: id, Initiated: time.Now()})
+
+	return id, nil
+}
+
+// UploadPart implements MultipartUploader.UploadPart by recording the part and storing its content,
+// optionally returning an error from PartErrorFunc.
+func (m *MockS3Uploader) UploadPart(_ context.Context, input *UploadPartInput) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.PartUploads = append(m.PartUploads, input)
+	if m.PartErrorFunc != nil {
+		if err := m.PartErrorFunc(input); err != nil {
+			return "", err
+		}
+	}
+	parts, ok := m.parts[input.UploadID]
+	if !ok || !m.inProgress(input.Bucket, input.UploadID) {
+		return "", ErrUploadNotFound
+	}
+	content, err := io.ReadAll(input.Body)
+	if err != nil {
+		return "", fmt.Errorf("mock: failed to read body: %w", err)
+	}
+	parts[input.Number] = content
+
+	return fmt.Sprintf("\"%x\"", md5.Sum(content)), nil // #nosec G401
+}
+
+// ListParts implements MultipartUploader.ListParts.
+func (m *MockS3Uploader) ListParts(_ context.Context, input *ListPartsInput) ([]*Part, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.inProgress(input.Bucket, input.UploadID) {
+		return nil, ErrUploadNotFound
+	}
+	var parts []*Part
+	for n, content := range m.parts[input.UploadID] {
+		parts = append(parts, &Part{Number: n, ETag: fmt.Sprintf("\"%x\"", md5.Sum(content)), Size: int64(len(content))}) // #nosec G401
+	}
+	slices.SortFunc(parts, func(a, b *Part) int { return int(a.Number - b.Number) })
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload implements MultipartUploader.CompleteMultipartUpload by storing the
+// object assembled from the parts, and removing the upload from MultipartUploads.
+func (m *MockS3Uploader) CompleteMultipartUpload(_ context.Context, input *CompleteMultipartUploadInput) (*UploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.inProgress(input.Bucket, input.UploadID) {
+		return nil, ErrUploadNotFound
+	}
+	var content []byte
+	for _, part := range input.Parts {
+		data, ok := m.parts[input.UploadID][part.Number]
+		if !ok || fmt.Sprintf("\"%x\"", md5.Sum(data)) != part.ETag { // #nosec G401
+			return nil, fmt.Errorf("mock: invalid part %d", part.Number)
+		}
+		content = append(content, data...)
+	}
+	m.MultipartUploads[input.Bucket] = slices.DeleteFunc(m.MultipartUploads[input.Bucket], func(mu *MultipartUpload) bool {
+		return mu.UploadID == input.UploadID
+	})
+
+	obj := &MockObject{Content: content, ETag: fmt.Sprintf("\"%x-%d\"", md5.Sum(content), len(input.Parts))} // #nosec G401
+	if m.Objects == nil {
+		m.Objects = map[string]*MockObject{}
+	}
+	m.Objects[input.Bucket+"/"+input.Key] = obj
+
+	return &UploadOutput{ETag: stringPtr(obj.ETag), VersionID: m.newVersion(obj)}, nil
+}
+
+// inProgress reports whether the multipart upload with the given ID is in MultipartUploads.
+func (m *MockS3Uploader) inProgress(bucket, id string) bool {
+	return slices.ContainsFunc(m.MultipartUploads[bucket], func(mu *MultipartUpload) bool { return mu.UploadID == id })
+}
+
+// Reset clears all recorded uploads, copies and deletes and resets the counter.
+func (m *MockS3Uploader) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Uploads = make([]*RecordedUpload, 0)
+	m.Copies = nil
+	m.Deletes = nil
+	m.ObjectCopies = nil
+	m.Objects = map[string]*MockObject{}
+	m.Versions = nil
+	m.MultipartUploads = nil
+	m.Aborts = nil
+	m.PartUploads = nil
+	m.parts = nil
+	m.UploadCount = 0
+}
+
+// GetUploadByKey returns the first upload matching the given key, or nil if not found.
+// Note: The returned pointer references internal test data and should not be modified by callers.
+func (m *MockS3Uploader) GetUploadByKey(key string) *RecordedUpload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.Uploads {
+		if u.Input.Key == key {
+			return u
+		}
+	}
+	return nil
+}
+
+// GetUploadsByBucket returns all uploads to the specified bucket.
+// Note: The returned pointers reference internal test data and should not be modified by callers.
+func (m *MockS3Uploader) GetUploadsByBucket(bucket string) []*RecordedUpload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*RecordedUpload
+	for _, u := range m.Uploads {
+		if u.Input.Bucket == bucket {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// stringPtr is a helper to get a pointer to a string.
+func stringPtr(s string) *string {
+	return &s
+}
+
+// --- Error injection helpers ---
+
+// ErrorOnKey returns an ErrorFunc that fails uploads matching the given key.
+func ErrorOnKey(key string, err error) func(*UploadInput) error {
+	return func(input *UploadInput) error {
+		if input.Key == key {
+			return err
+		}
+		return nil
+	}
+}
+
+// ErrorOnAttempt returns an ErrorFunc that fails the Nth upload attempt (1-indexed).
+func ErrorOnAttempt(attempt int, err error) func(*UploadInput) error {
+	count := 0
+	var mu sync.Mutex
+	return func(input *UploadInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		if count == attempt {
+			return err
+		}
+		return nil
+	}
+}
+
+// ErrorAlways returns an ErrorFunc that fails all uploads.
+func ErrorAlways(err error) func(*UploadInput) error {
+	return func(input *UploadInput) error {
+		return err
+	}
+}
+
+// ErrorNTimes returns an ErrorFunc that fails the first N uploads, then succeeds.
+func ErrorNTimes(n int, err error) func(*UploadInput) error {
+	count := 0
+	var mu sync.Mutex
+	return func(input *UploadInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		if count <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+// --- Common test errors ---
+
+// RecoverableS3Error represents a transient S3 error that should trigger retries.
+type RecoverableS3Error struct {
+	Message string
+}
+
+func (e *RecoverableS3Error) Error() string {
+	return e.Message
+}
+
+// NewRecoverableError creates an error that matches the recoverable error patterns.
+func NewRecoverableError() error {
+	return &RecoverableS3Error{Message: "RequestTimeout: request timed out. Idle connections will be closed."}
+}
+
+// NewNetworkError creates a simulated network error.
+func NewNetworkError() error {
+	return &RecoverableS3Error{Message: "dial tcp: lookup s3.amazonaws.com: no such host"}
+}
+
+// FatalS3Error represents a permanent S3 error that should not be retried.
+type FatalS3Error struct {
+	Message string
+}
+
+func (e *FatalS3Error) Error() string {
+	return e.Message
+}
+
+// NewAccessDeniedError creates a simulated access denied error.
+func NewAccessDeniedError() error {
+	return &FatalS3Error{Message: "AccessDenied: Access Denied"}
+}
+
+// NewBucketNotFoundError creates a simulated bucket not found error.
+func NewBucketNotFoundError() error {
+	return &FatalS3Error{Message: "NoSuchBucket: The specified bucket does not exist"}
+}
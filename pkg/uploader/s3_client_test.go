@@ -1,12 +1,19 @@
-package main
+package uploader
 
 import (
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 func TestMockS3Uploader_RecordsUploads(t *testing.T) {
@@ -270,3 +277,62 @@ func TestMockS3Uploader_ConcurrentUploads(t *testing.T) {
 		t.Errorf("expected 10 uploads, got %d", mock.UploadCount)
 	}
 }
+
+// newTestSDKUploader returns an S3UploaderSDK talking to a test server answering every request with
+// the given status and body.
+func newTestSDKUploader(t *testing.T, status int, body string) (*S3UploaderSDK, http.Header) {
+	t.Helper()
+
+	received := http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range r.Header {
+			received[k] = v
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(srv.URL),
+		UsePathStyle:     true,
+		Credentials:      credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		RetryMaxAttempts: 1,
+	})
+
+	return NewS3UploaderWithClient(client), received
+}
+
+func TestS3UploaderSDKCopyMetadataNotFound(t *testing.T) {
+	up, _ := newTestSDKUploader(t, http.StatusNotFound,
+		`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>gone</Message></Error>`)
+
+	_, err := up.CopyMetadata(context.Background(), &UploadInput{Bucket: testBucket, Key: "gone.html"})
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Error("Expected ErrObjectNotFound, got", err)
+	}
+}
+
+func TestS3UploaderSDKCopyEncrypted(t *testing.T) {
+	up, received := newTestSDKUploader(t, http.StatusOK,
+		`<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"abc"</ETag></CopyObjectResult>`)
+
+	_, err := up.Copy(context.Background(), &CopyInput{Bucket: testBucket, Key: "index.html", SourceKey: "releases/1/index.html", ServerSideEncryption: &sse})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if v := received.Get("X-Amz-Server-Side-Encryption"); v != sse {
+		t.Errorf("Expected the copy to be encrypted, got %q", v)
+	}
+}
+
+func TestS3UploaderSDKCurrentVersionNotFound(t *testing.T) {
+	up, _ := newTestSDKUploader(t, http.StatusNotFound, "")
+
+	_, err := up.CurrentVersion(context.Background(), &HeadInput{Bucket: testBucket, Key: "gone.html"})
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Error("Expected ErrObjectNotFound, got", err)
+	}
+}
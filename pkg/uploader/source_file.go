@@ -0,0 +1,238 @@
+package uploader
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers
+const (
+	ContentEncoding = "Content-Encoding"
+	CacheControl    = "Cache-Control"
+	ContentType     = "Content-Type"
+	// pseudo headers
+	Encryption = "EncryptionON"
+)
+
+var sse = "AES256"
+
+// Headers maps header names (e.g. ContentType) to their values.
+type Headers map[string]string
+
+// HeaderRule gives the headers to upload the files matching Pattern with.
+type HeaderRule struct {
+	Pattern *regexp.Regexp
+	Headers Headers
+}
+
+// DefaultHeaderRules are the header rules used unless Options.HeaderRules is set.
+// Order matters: first hit, first served.
+var DefaultHeaderRules = []HeaderRule{
+	{regexp.MustCompile("index\\.html"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=1800"}},       // 1800
+	{regexp.MustCompile("articole.*\\.html$"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=3600"}}, // 86400
+	{regexp.MustCompile("[^/]*\\.html$"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=3600"}},
+	{regexp.MustCompile("\\.xml$"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=1800"}},
+	{regexp.MustCompile("\\.ico$"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=31536000"}},
+	{regexp.MustCompile("\\.(js|css)$"), Headers{ContentEncoding: "gzip", CacheControl: "max-age=31536000"}},
+	{regexp.MustCompile("images/articole/.*(jpg|JPG|png|PNG)$"), Headers{CacheControl: "max-age=31536000"}},
+	{regexp.MustCompile("\\.(jpg|JPG|png|PNG)$"), Headers{CacheControl: "max-age=31536000"}},
+}
+
+type sourceFile struct {
+	fname string
+	fpath string
+	key   string // the remote object key
+	size  int64
+	md5   string
+	hdrs  Headers
+
+	action      string // the planned action, see PlanFile
+	encrypt     bool
+	attempts    int
+	gzip        bool
+	headersOnly bool // only the headers changed, update them in place instead of re-uploading
+	delete      bool // the file was removed from the source folder, delete its remote object
+
+	// remote details, set on successful upload (or delete, for the version)
+	etag       string
+	versionID  string
+	uploadedAt time.Time
+
+	// the object replaced, looked up before the first attempt, see Syncer.previousVersion
+	prevVersionID                       string
+	prevLooked, prevExists, prevChecked bool
+
+	sync.Mutex
+}
+
+func (h *Headers) merge(other Headers) {
+	for key, val := range other {
+		(*h)[key] = val
+	}
+}
+
+func (h *Headers) equal(other Headers) bool {
+	if len(*h) != len(other) {
+		return false
+	}
+	for k, val1 := range *h {
+		if val2 := other[k]; val1 != val2 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newSourceFile(o *Options, fname string) *sourceFile {
+	sf := &sourceFile{fname: fname, fpath: filepath.Join(o.Source, fname), key: o.Prefix + fname, encrypt: o.Encrypt}
+	sf.hdrs = Headers{ContentType: mime.TypeByExtension(strings.ToLower(filepath.Ext(fname)))}
+
+	rules := DefaultHeaderRules
+	if o.HeaderRules != nil {
+		rules = o.HeaderRules
+	}
+
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(fname) {
+			sf.hdrs.merge(rule.Headers)
+			break
+		}
+	}
+	sf.gzip = (sf.hdrs[ContentEncoding] == "gzip")
+
+	return sf
+}
+
+func (s *sourceFile) getHeader(hdr string) *string {
+	if hdr == Encryption {
+		if s.encrypt {
+			return &sse
+		}
+
+		return nil
+	} else if v, ok := s.hdrs[hdr]; ok {
+		return &v
+	}
+
+	return nil
+}
+
+// headersHash fingerprints the headers the file would be uploaded with, so that changing
+// the header rules is detected even if the file content stays the same.
+func (s *sourceFile) headersHash() string {
+	keys := make([]string, 0, len(s.hdrs))
+	for k := range s.hdrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := md5.New() // #nosec G401 - used for change detection, not crypto
+	for _, k := range keys {
+		_, _ = hash.Write([]byte(k + ": " + s.hdrs[k] + "\n"))
+	}
+	if v := s.getHeader(Encryption); v != nil {
+		_, _ = hash.Write([]byte(Encryption + ": " + *v + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// uploadInput builds the S3 upload parameters for the file, with the given body.
+func (s *sourceFile) uploadInput(bucket string, body io.Reader) *UploadInput {
+	return &UploadInput{
+		Bucket:               bucket,
+		Key:                  s.key,
+		Body:                 body,
+		Size:                 s.bodySize(),
+		ContentType:          s.getHeader(ContentType),
+		ContentEncoding:      s.getHeader(ContentEncoding),
+		CacheControl:         s.getHeader(CacheControl),
+		ServerSideEncryption: s.getHeader(Encryption),
+	}
+}
+
+// recordUpload stores the remote details returned by a successful upload.
+func (s *sourceFile) recordUpload(out *UploadOutput) {
+	if out != nil && out.ETag != nil {
+		s.etag = *out.ETag
+	}
+	if out != nil && out.VersionID != nil {
+		s.versionID = *out.VersionID
+	}
+	s.uploadedAt = time.Now().UTC()
+}
+
+// runFile returns the change made to the file, as uploaded: created only if there was no object,
+// when that could be checked.
+func (s *sourceFile) runFile() *RunFile {
+	f := &RunFile{Action: s.action, Name: s.fname, Key: s.key, ETag: s.etag, VersionID: s.versionID,
+		PreviousVersionID: s.prevVersionID, PreviousChecked: s.prevChecked}
+	switch {
+	case !s.prevChecked || s.delete:
+	case !s.prevExists:
+		f.Action = ActionCreate
+	case f.Action == ActionCreate:
+		f.Action = ActionUpdate
+	}
+
+	return f
+}
+
+// verbs returns what is being done with the file, in the present and past tense, for messages.
+func (s *sourceFile) verbs() (string, string) {
+	if s.delete {
+		return "delete", "Deleted"
+	}
+
+	return "upload", "Uploaded"
+}
+
+// method returns the name of the S3Uploader method that carries out the change to the file.
+func (s *sourceFile) method() string {
+	switch {
+	case s.delete:
+		return "Delete"
+	case s.headersOnly:
+		return "CopyMetadata"
+	default:
+		return "Upload"
+	}
+}
+
+// bytes returns the size of the content sent for the file, none for deletes and header updates.
+func (s *sourceFile) bytes() int64 {
+	if s.delete || s.headersOnly {
+		return 0
+	}
+
+	return s.size
+}
+
+// bodySize returns an upper bound of the size of the uploaded body: the file size, plus the worst
+// case overhead of gzip for the files compressed on the fly, whose size is not known upfront.
+func (s *sourceFile) bodySize() int64 {
+	if !s.gzip {
+		return s.size
+	}
+
+	return s.size + s.size/1000 + 64
+}
+
+func (s *sourceFile) recordAttempt() {
+	s.Lock()
+	s.attempts++
+	s.Unlock()
+}
+
+func (s *sourceFile) retriable() bool {
+	return s.attempts < maxTries
+}
@@ -1,6 +1,7 @@
-package main
+package uploader
 
 import (
+	"regexp"
 	"sync"
 	"testing"
 )
@@ -8,9 +9,9 @@ import (
 const testHTMLFile = "foobar.html"
 
 func TestHeadersMerge(t *testing.T) {
-	h1, h2 := headers{"foo": "foo1", "bar": "bar1"},
-		headers{"baz": "baz1"}
-	expected := headers{"foo": "foo1", "bar": "bar1", "baz": "baz1"}
+	h1, h2 := Headers{"foo": "foo1", "bar": "bar1"},
+		Headers{"baz": "baz1"}
+	expected := Headers{"foo": "foo1", "bar": "bar1", "baz": "baz1"}
 
 	h1.merge(h2)
 	if !h1.equal(expected) {
@@ -19,9 +20,9 @@ func TestHeadersMerge(t *testing.T) {
 }
 
 func TestHeaderEqual(t *testing.T) {
-	h1, h2, h3 := headers{"foo": "foo1", "bar": "bar1"},
-		headers{"foo": "foo1", "bar": "bar1"},
-		headers{"foo": "foo1"}
+	h1, h2, h3 := Headers{"foo": "foo1", "bar": "bar1"},
+		Headers{"foo": "foo1", "bar": "bar1"},
+		Headers{"foo": "foo1"}
 
 	if !h1.equal(h2) {
 		t.Errorf("Expected %v to equal %v", h1, h2)
@@ -34,14 +35,14 @@ func TestHeaderEqual(t *testing.T) {
 
 func TestNewSourceFile(t *testing.T) {
 	fname := testHTMLFile
-	sf := newSourceFile(fname)
-	expectedHdrs := headers{ContentType: "text/html; charset=utf-8", ContentEncoding: "gzip", CacheControl: "max-age=3600"}
+	sf := newSourceFile(testOptions, fname)
+	expectedHdrs := Headers{ContentType: "text/html; charset=utf-8", ContentEncoding: "gzip", CacheControl: "max-age=3600"}
 
 	if sf.fname != fname {
 		t.Errorf("Expected fname to be set to %s got %s", fname, sf.fname)
 	}
 
-	if fpath := opts.Source + "/" + fname; sf.fpath != fpath {
+	if fpath := testSource + "/" + fname; sf.fpath != fpath {
 		t.Errorf("Expected fpath to be set to %s got %s", fpath, sf.fpath)
 	}
 
@@ -60,8 +61,8 @@ func TestNewSourceFile(t *testing.T) {
 	}
 
 	for fname, ttl := range tests {
-		sf = newSourceFile(fname)
-		expectedHdrs = headers{ContentType: "text/html; charset=utf-8", ContentEncoding: "gzip", CacheControl: "max-age=" + ttl}
+		sf = newSourceFile(testOptions, fname)
+		expectedHdrs = Headers{ContentType: "text/html; charset=utf-8", ContentEncoding: "gzip", CacheControl: "max-age=" + ttl}
 		if !sf.hdrs.equal(expectedHdrs) {
 			t.Errorf("Expected hdrs to be set to %v got %v", expectedHdrs, sf.hdrs)
 		}
@@ -70,7 +71,7 @@ func TestNewSourceFile(t *testing.T) {
 
 func TestSourceFileAttempted(t *testing.T) {
 	fname := testHTMLFile
-	sf := newSourceFile(fname)
+	sf := newSourceFile(testOptions, fname)
 	wg := new(sync.WaitGroup)
 
 	wg.Add(2)
@@ -95,9 +96,22 @@ func TestSourceFileAttempted(t *testing.T) {
 	}
 }
 
+func TestSourceFileBodySize(t *testing.T) {
+	sf := newSourceFile(testOptions, testHTMLFile)
+	sf.size = 1 << 30
+	if !sf.gzip || sf.uploadInput(testBucket, nil).Size <= sf.size {
+		t.Error("Expected the body size of a gzipped file to allow for the gzip overhead, got", sf.bodySize())
+	}
+
+	sf.gzip = false
+	if got := sf.uploadInput(testBucket, nil).Size; got != sf.size {
+		t.Errorf("Expected the body size to be %d, got %d", sf.size, got)
+	}
+}
+
 func TestSourceFileRetriable(t *testing.T) {
 	fname := testHTMLFile
-	sf := newSourceFile(fname)
+	sf := newSourceFile(testOptions, fname)
 
 	if sf.attempts = 0; !sf.retriable() {
 		t.Fatal("A source file with no attempts should be retriable")
@@ -115,3 +129,31 @@ func TestSourceFileRetriable(t *testing.T) {
 		t.Fatal("A source file with more than maxTries attempts should NOT be retriable")
 	}
 }
+
+func TestSourceFileHeadersHash(t *testing.T) {
+	sf1, sf2 := newSourceFile(testOptions, "foo.html"), newSourceFile(testOptions, "bar.html")
+	if sf1.headersHash() != sf2.headersHash() {
+		t.Error("Expected files with the same headers to have the same fingerprint")
+	}
+
+	sf2.hdrs[CacheControl] = "max-age=1"
+	if sf1.headersHash() == sf2.headersHash() {
+		t.Error("Expected a header change to change the fingerprint")
+	}
+
+	if h := newSourceFile(&Options{Encrypt: true}, "foo.html").headersHash(); h == sf1.headersHash() {
+		t.Error("Expected toggling encryption to change the fingerprint")
+	}
+}
+
+func TestNewSourceFileCustomHeaders(t *testing.T) {
+	o := &Options{HeaderRules: []HeaderRule{{regexp.MustCompile("\\.html$"), Headers{CacheControl: "max-age=60"}}}}
+	sf := newSourceFile(o, testHTMLFile)
+	expectedHdrs := Headers{ContentType: "text/html; charset=utf-8", CacheControl: "max-age=60"}
+	if !sf.hdrs.equal(expectedHdrs) {
+		t.Errorf("Expected hdrs to be set to %v got %v", expectedHdrs, sf.hdrs)
+	}
+	if sf.gzip {
+		t.Error("Expected the file not to be compressed")
+	}
+}
@@ -0,0 +1,389 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Errors returned by Syncer. They wrap the underlying error.
+var (
+	// ErrCaching is returned when the cache cannot be loaded or saved, or the source folder scanned.
+	ErrCaching = errors.New("caching failed")
+	// ErrPlanOutOfDate is returned by Apply when the saved plan no longer matches the source folder.
+	ErrPlanOutOfDate = errors.New("plan is out of date")
+)
+
+// Options configures a Syncer. The zero value uploads and caches everything that changed.
+type Options struct {
+	// Bucket to upload to.
+	Bucket string
+	// Source folder with the files to be uploaded.
+	Source string
+	// Prefix is prepended to the keys of the files, e.g. "releases/1/". None if empty.
+	Prefix string
+	// Workers is the number of concurrent uploads, twice the number of CPUs if 0.
+	Workers int
+
+	// Encrypt turns server side encryption on.
+	Encrypt bool
+	// Paranoid hashes every file, even if its size and modification time did not change.
+	Paranoid bool
+	// CopyHeaders updates the headers of the files whose content did not change in place,
+	// instead of re-uploading them.
+	CopyHeaders bool
+	// Delete deletes the objects of the files removed from the source folder.
+	Delete bool
+	// DryRun goes through the motions, without changing the bucket or the cache.
+	DryRun bool
+	// SkipUpload and SkipCache skip uploading the files and saving the cache, respectively.
+	// Skipping the upload, but not the cache, rebuilds the cache from the source folder.
+	SkipUpload, SkipCache bool
+
+	// HeaderRules give the headers of each file, DefaultHeaderRules are used if nil.
+	HeaderRules []HeaderRule
+	// Phases order the uploads: the files matching none of the patterns are uploaded first, then
+	// those matching each pattern in turn (e.g. the HTML files once the assets they reference
+	// are out), each phase only once the previous one fully succeeded. Deletions always come last.
+	Phases []*regexp.Regexp
+
+	// ResumeDir is the folder where the state of the multipart uploads of the large files (those
+	// over PartSize, not gzipped) is kept, for an interrupted upload to resume where it stopped on
+	// the next attempt or run (see LeavePartsOnError), if the file did not change meanwhile. Large
+	// files are uploaded in one go if empty, or if the S3Uploader is not a MultipartUploader.
+	ResumeDir string
+	// PartSize is the size of the parts of the resumable uploads, 5 MiB if 0. It is raised as
+	// needed for the file to fit in 10,000 parts.
+	PartSize int64
+	// PartConcurrency is the number of parts of a file uploaded at once, 5 if 0.
+	PartConcurrency int
+	// LeavePartsOnError keeps the resumable upload of a file that failed for good, after all the
+	// retries, for a later run to resume. It is aborted, with its state, otherwise. The uploads of
+	// an interrupted run are always left to resume.
+	LeavePartsOnError bool
+
+	// RecordVersions looks up the current version of each object before changing it, with an extra
+	// request per file, to record the version replaced and whether the object is new (see RunFile),
+	// as needed to roll the run back. The files are recorded with their planned action otherwise.
+	RecordVersions bool
+
+	// OnAttempt, if set, is called after every attempt at uploading, updating or deleting a file,
+	// or rolling it back, e.g. to collect metrics. It is called from the upload workers concurrently.
+	OnAttempt func(Attempt)
+}
+
+// Attempt describes an attempt at changing an object, as given to Options.OnAttempt.
+type Attempt struct {
+	// Key of the object.
+	Key string
+	// Bytes uploaded, 0 for deletions and header updates.
+	Bytes int64
+	// N is the number of the attempt, from 1.
+	N int
+	// Duration is how long the attempt took.
+	Duration time.Duration
+	// Err is the error the attempt failed with, nil if it succeeded. Retry tells whether the file
+	// is to be tried again and Class classifies the error: the S3 error code, network or other.
+	Err   error
+	Retry bool
+	Class string
+}
+
+// Result describes what a run did.
+type Result struct {
+	// Plan is the plan that was carried out.
+	Plan *Plan
+	// Uploaded lists the files uploaded (or whose headers were updated), Deleted the files whose
+	// objects were deleted and Rejected the files that failed, after all the retries.
+	Uploaded, Deleted, Rejected []string
+	// Skipped lists the files left alone because an earlier phase failed (see Options.Phases).
+	Skipped []string
+	// Keys lists the keys of the objects uploaded, updated or deleted, e.g. to invalidate a CDN.
+	Keys []string
+	// Files details the changes made, with the object versions, e.g. for a RunManifest.
+	Files []*RunFile
+	// Unchanged counts the files that did not need uploading.
+	Unchanged int
+	// Duration is how long the run took.
+	Duration time.Duration
+}
+
+// Syncer uploads the files in a folder that changed since the last run to a bucket,
+// keeping track of what was uploaded in a cache.
+type Syncer struct {
+	opts  Options
+	up    S3Uploader
+	log   *slog.Logger
+	store CacheStore
+
+	// backoff returns how long to wait before retrying a file that failed attempts times.
+	backoff func(attempts int) time.Duration
+}
+
+// New returns a Syncer that uploads with up and keeps its cache in store. log may be nil.
+//
+// Every file uploaded (or deleted) is logged at the info level, with its key, bytes, attempt and
+// duration; retries are logged as warnings and the files that fail for good as errors.
+func New(opts Options, up S3Uploader, log *slog.Logger, store CacheStore) *Syncer {
+	if log == nil {
+		log = slog.New(slog.DiscardHandler)
+	}
+
+	return &Syncer{opts: opts, up: up, log: log, store: store, backoff: exponentialBackoff}
+}
+
+// exponentialBackoff waits 200ms after the first attempt, doubling the wait after each one.
+func exponentialBackoff(attempts int) time.Duration {
+	return time.Duration(100.0*math.Pow(2, float64(attempts))) * time.Millisecond
+}
+
+// workers returns the number of upload workers to use.
+func (s *Syncer) workers() int {
+	if s.opts.Workers == 0 {
+		return runtime.NumCPU() * 2
+	}
+
+	return s.opts.Workers
+}
+
+// Plan returns the changes Run would make, without making them.
+func (s *Syncer) Plan(ctx context.Context) (p *Plan, err error) {
+	ctx, span := tracer.Start(ctx, "plan", s.runAttributes())
+	defer func() { endSpan(span, err) }()
+
+	_, _, p, err = s.plan(ctx)
+	return p, err
+}
+
+// Run uploads the new and changed files, updates the headers of those whose header rules changed,
+// deletes the removed ones (if Options.Delete is set) and saves the cache.
+func (s *Syncer) Run(ctx context.Context) (*Result, error) {
+	return s.Apply(ctx, nil)
+}
+
+// Apply is Run, carrying out the saved plan instead of a freshly computed one. The saved plan
+// must make the exact same changes as a fresh one, i.e. nothing changed since it was made,
+// otherwise Apply fails with ErrPlanOutOfDate. A nil plan is the same as Run.
+//
+// Each run is traced, with child spans for loading the cache, hashing, diffing, each upload
+// (and the waits before retrying it) and saving the cache.
+func (s *Syncer) Apply(ctx context.Context, saved *Plan) (res *Result, err error) {
+	ctx, span := tracer.Start(ctx, "sync", s.runAttributes())
+	defer func() {
+		if res != nil {
+			span.SetAttributes(attribute.Int("uploaded", len(res.Uploaded)), attribute.Int("deleted", len(res.Deleted)),
+				attribute.Int("rejected", len(res.Rejected)), attribute.Int("unchanged", res.Unchanged))
+		}
+		endSpan(span, err)
+	}()
+
+	return s.apply(ctx, saved)
+}
+
+// runAttributes returns the span attributes describing the run.
+func (s *Syncer) runAttributes() trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("bucket", s.opts.Bucket), attribute.String("source", s.opts.Source),
+		attribute.Bool("dry_run", s.opts.DryRun))
+}
+
+// apply carries out Apply.
+func (s *Syncer) apply(ctx context.Context, saved *Plan) (*Result, error) {
+	start := time.Now()
+	current, old, p, err := s.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		if err := saved.Matches(p); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPlanOutOfDate, err)
+		}
+		p = saved
+	}
+
+	res := &Result{Plan: p, Unchanged: len(current) - p.Summary.Create - p.Summary.Update - p.Summary.UpdateHeaders}
+	if p.Empty() {
+		s.log.Info("Nothing to upload")
+		res.Duration = time.Since(start)
+		return res, nil
+	}
+	s.log.Info("Syncing", "bucket", s.opts.Bucket, "upload", p.Summary.Create+p.Summary.Update,
+		"update_headers", p.Summary.UpdateHeaders, "delete", p.Summary.Delete, "bytes", p.Summary.Bytes)
+
+	var srcs []*sourceFile
+	var skipped []string
+	rejected := &syncedList{}
+	if s.opts.SkipUpload {
+		s.log.Info("Skipping upload")
+	} else {
+		srcs = p.sources()
+		skipped = s.uploadPhases(ctx, srcs, rejected)
+		s.log.Info("Done uploading files")
+
+		for _, src := range srcs {
+			current.recordUpload(src)
+		}
+		if !s.opts.DryRun {
+			res.collect(srcs, rejected.list, skipped)
+		}
+	}
+
+	switch {
+	case s.opts.SkipCache:
+		s.log.Info("Skipping cache")
+	case s.opts.DryRun:
+		s.log.Info("Pretending to update cache")
+	default:
+		unsynced := append(slices.Clone(rejected.list), skipped...)
+		current = current.reject(unsynced)
+		for _, src := range srcs {
+			// Keep the files that failed to be deleted, so that they are deleted on the next run.
+			if src.delete && slices.Contains(unsynced, src.fname) {
+				current[src.fname] = old[src.fname]
+			}
+		}
+		if err := s.saveCache(ctx, current); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCaching, err)
+		}
+		s.log.Info("Done updating cache")
+	}
+
+	res.Duration = time.Since(start)
+	s.log.Info("All done", "uploaded", len(res.Uploaded), "deleted", len(res.Deleted), "rejected", len(res.Rejected),
+		"skipped", len(res.Skipped), "duration", res.Duration)
+
+	return res, nil
+}
+
+// saveCache saves the cache to the store.
+func (s *Syncer) saveCache(ctx context.Context, fc FileCache) (err error) {
+	ctx, span := tracer.Start(ctx, "cache.save", trace.WithAttributes(attribute.Int("files", len(fc))))
+	defer func() { endSpan(span, err) }()
+
+	return s.store.Save(ctx, fc)
+}
+
+// collect fills in the uploaded, deleted, rejected and skipped files, and the keys and files
+// changed, the latter with the versions they replaced, as looked up before changing them.
+func (r *Result) collect(srcs []*sourceFile, rejected, skipped []string) {
+	r.Uploaded, r.Deleted, r.Rejected, r.Keys = []string{}, []string{}, sorted(rejected), []string{}
+	r.Skipped, r.Files = sorted(skipped), []*RunFile{}
+	for _, src := range srcs {
+		switch {
+		case slices.Contains(rejected, src.fname), slices.Contains(skipped, src.fname):
+			continue
+		case src.delete:
+			r.Deleted = append(r.Deleted, src.fname)
+		default:
+			r.Uploaded = append(r.Uploaded, src.fname)
+		}
+		r.Keys = append(r.Keys, src.key)
+
+		r.Files = append(r.Files, src.runFile())
+	}
+}
+
+// plan computes the current files list, loads the old one and builds the plan for the difference.
+func (s *Syncer) plan(ctx context.Context) (current, old FileCache, p *Plan, err error) {
+	current, old, diff, hdrDiff, err := s.filesLists(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %w", ErrCaching, err)
+	}
+
+	return current, old, newPlan(&s.opts, current, old, diff, hdrDiff), nil
+}
+
+// filesLists returns the current and the old (cached) files lists, as well as the difference between them.
+// The difference is split in files that need to be uploaded and files that only need their headers updated.
+// Unless Options.CopyHeaders is set, the latter are uploaded as well.
+func (s *Syncer) filesLists(ctx context.Context) (current, old FileCache, diff, hdrDiff []string, err error) {
+	if old, err = s.loadCache(ctx); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("loading cache: %w", err)
+	}
+
+	if current, err = s.scan(ctx, old); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("scanning source: %w", err)
+	}
+	if err = old.migrate(s.opts.Source, current); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("migrating cache: %w", err)
+	}
+	current.inherit(old)
+
+	_, span := tracer.Start(ctx, "diff")
+	diff, hdrDiff = current.diff(old)
+	if !s.opts.CopyHeaders {
+		diff, hdrDiff = append(diff, hdrDiff...), []string{}
+	}
+	span.SetAttributes(attribute.Int("changed", len(diff)), attribute.Int("headers_changed", len(hdrDiff)))
+	span.End()
+
+	return current, old, diff, hdrDiff, nil
+}
+
+// loadCache loads the cache from the store.
+func (s *Syncer) loadCache(ctx context.Context) (fc FileCache, err error) {
+	ctx, span := tracer.Start(ctx, "cache.load")
+	defer func() { endSpan(span, err) }()
+
+	fc, err = s.store.Load(ctx)
+	span.SetAttributes(attribute.Int("files", len(fc)))
+
+	return fc, err
+}
+
+// scan walks and hashes the source folder, see scanSource.
+func (s *Syncer) scan(ctx context.Context, old FileCache) (fc FileCache, err error) {
+	_, span := tracer.Start(ctx, "hash", trace.WithAttributes(attribute.Bool("paranoid", s.opts.Paranoid)))
+	defer func() { endSpan(span, err) }()
+
+	fc, err = scanSource(&s.opts, old)
+	span.SetAttributes(attribute.Int("files", len(fc)))
+
+	return fc, err
+}
+
+// uploadAll uploads (or deletes) all the source files, using a pool of workers.
+// The files that fail, after all the retries, are added to rejected.
+func (s *Syncer) uploadAll(ctx context.Context, srcs []*sourceFile, rejected *syncedList) {
+	ctx, span := tracer.Start(ctx, "upload", trace.WithAttributes(attribute.Int("files", len(srcs)),
+		attribute.Int("workers", s.workers())))
+	defer span.End()
+
+	uploads := make(chan *sourceFile)
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+	put := func(src *sourceFile) error {
+		return s.put(ctx, src)
+	}
+
+	wgUploads.Add(len(srcs))
+	wgWorkers.Add(s.workers())
+	for i := 0; i < s.workers(); i++ {
+		go s.upload(ctx, put, uploads, rejected, wgUploads, wgWorkers)
+	}
+
+	for _, src := range srcs {
+		uploads <- src
+	}
+
+	wgUploads.Wait()
+	close(uploads)
+	wgWorkers.Wait()
+}
+
+// sorted returns a sorted copy of list.
+func sorted(list []string) []string {
+	out := append([]string(nil), list...)
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,227 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSource    = "../../test/output"
+	testCacheFile = "../../test/.go3up.txt"
+	testBucket    = "example_bucket"
+)
+
+var testOptions = &Options{Source: testSource, Bucket: testBucket}
+
+// newTestSyncer returns a Syncer for the test source folder, with an empty cache in a temporary
+// file and no waiting between retries.
+func newTestSyncer(t *testing.T, o Options, up S3Uploader) *Syncer {
+	t.Helper()
+
+	if o.Source == "" {
+		o.Source = testSource
+	}
+	if o.Bucket == "" {
+		o.Bucket = testBucket
+	}
+
+	s := New(o, up, nil, NewLocalCacheStore(filepath.Join(t.TempDir(), "cache.txt")))
+	s.backoff = func(int) time.Duration { return time.Nanosecond }
+
+	return s
+}
+
+func TestFilesList(t *testing.T) {
+	s := newTestSyncer(t, Options{}, nil)
+	s.store = NewLocalCacheStore("../../test/.cacheEmpty.txt")
+	current, _, diff, _, err := s.filesLists(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	if current["barbaz.txt"].MD5 != "b6652a32e3a09e8f279f4cc1f794ba00" ||
+		current["foobar.html"].MD5 != "35c9c9c7c90ad764bae9e2623f522c24" {
+		t.Error("Current list does not match expectation")
+	}
+
+	sort.Strings(diff)
+	if strings.Join(diff, ":") != "barbaz.txt:foobar.html" {
+		t.Error("Expected diff to hold barbaz.txt and foobar.html")
+	}
+}
+
+func TestSyncerRun(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{}, mock)
+
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(res.Uploaded, ":") != "barbaz.txt:foobar.html" || len(res.Rejected) != 0 || res.Unchanged != 0 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if len(mock.Uploads) != 2 || mock.Objects[testBucket+"/foobar.html"] == nil {
+		t.Fatal("Expected both files to be uploaded, got", len(mock.Uploads))
+	}
+
+	// Everything is cached now, so there is nothing left to do.
+	res, err = s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !res.Plan.Empty() || res.Unchanged != 2 || len(mock.Uploads) != 2 {
+		t.Errorf("Expected nothing to be uploaded the second time, got %+v", res)
+	}
+}
+
+func TestSyncerRunRejected(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	mock.ErrorFunc = ErrorOnKey("foobar.html", NewAccessDeniedError())
+	s := newTestSyncer(t, Options{}, mock)
+
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(res.Uploaded, ":") != "barbaz.txt" || strings.Join(res.Rejected, ":") != "foobar.html" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+
+	// The rejected file is not cached, so it is retried on the next run.
+	mock.ErrorFunc = nil
+	if res, err = s.Run(ctx); err != nil || strings.Join(res.Uploaded, ":") != "foobar.html" {
+		t.Errorf("Expected the rejected file to be uploaded again, got %+v %v", res, err)
+	}
+}
+
+func TestSyncerLog(t *testing.T) {
+	mock := NewMockS3Uploader()
+	mock.ErrorFunc = ErrorOnKey("foobar.html", NewAccessDeniedError())
+	s := newTestSyncer(t, Options{}, mock)
+	buf := &bytes.Buffer{}
+	s.log = slog.New(slog.NewJSONHandler(buf, nil))
+
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	records := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		rec := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if key, ok := rec["key"].(string); ok {
+			records[key] = rec
+		}
+	}
+
+	up := records["barbaz.txt"]
+	if up["level"] != "INFO" || up["msg"] != "Uploaded" || up["bytes"] != 8.0 || up["attempt"] != 1.0 || up["duration"] == nil {
+		t.Errorf("Unexpected upload record %v", up)
+	}
+	if failed := records["foobar.html"]; failed["level"] != "ERROR" || failed["error"] == nil {
+		t.Errorf("Unexpected failure record %v", failed)
+	}
+}
+
+func TestSyncerRebuildCache(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{SkipUpload: true}, mock)
+
+	if _, err := s.Run(ctx); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(mock.Uploads) != 0 {
+		t.Error("Expected nothing to be uploaded, got", len(mock.Uploads))
+	}
+
+	p, err := s.Plan(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !p.Empty() {
+		t.Error("Expected no changes after rebuilding the cache, got", p.Files)
+	}
+}
+
+func TestSyncerApply(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{}, mock)
+
+	p, err := s.Plan(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(mock.Uploads) != 0 {
+		t.Fatal("Expected planning not to upload anything")
+	}
+
+	if _, err = s.Apply(ctx, p); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(mock.Uploads) != 2 {
+		t.Fatal("Expected the planned uploads, got", len(mock.Uploads))
+	}
+
+	// Everything is uploaded now, so the plan is out of date.
+	if _, err = s.Apply(ctx, p); !errors.Is(err, ErrPlanOutOfDate) {
+		t.Error("Expected applying the plan again to fail, got", err)
+	}
+}
+
+func TestSyncerDelete(t *testing.T) {
+	ctx, mock := context.Background(), NewMockS3Uploader()
+	s := newTestSyncer(t, Options{Delete: true}, mock)
+
+	old := FileCache{
+		"gone.html":  &CacheEntry{Name: "gone.html", MD5: "1", Key: "gone.html"},
+		"stuck.html": &CacheEntry{Name: "stuck.html", MD5: "2", Key: "stuck.html"},
+	}
+	if err := s.store.Save(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	mock.ErrorFunc = ErrorOnKey("stuck.html", NewAccessDeniedError())
+
+	res, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(res.Deleted, ":") != "gone.html" || strings.Join(res.Rejected, ":") != "stuck.html" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if keys := sorted(res.Keys); strings.Join(keys, ":") != "barbaz.txt:foobar.html:gone.html" {
+		t.Error("Expected the keys of the files uploaded and deleted, got", keys)
+	}
+
+	// The file that failed to be deleted is kept in the cache, to be deleted on the next run.
+	fc, err := s.store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc["gone.html"] != nil || fc["stuck.html"] == nil {
+		t.Error("Expected only the deleted file to be dropped from the cache, got", fc.Names())
+	}
+}
+
+func TestSyncerPrefix(t *testing.T) {
+	mock := NewMockS3Uploader()
+	s := newTestSyncer(t, Options{Prefix: "releases/1/"}, mock)
+
+	res, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if keys := sorted(res.Keys); strings.Join(keys, ":") != "releases/1/barbaz.txt:releases/1/foobar.html" {
+		t.Error("Expected the keys to be prefixed, got", keys)
+	}
+}
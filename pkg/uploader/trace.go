@@ -0,0 +1,20 @@
+package uploader
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the runs, using the global tracer provider: nothing is recorded unless
+// the program using the package sets one up.
+var tracer = otel.Tracer("github.com/petems/go-s3-uploader/pkg/uploader")
+
+// endSpan ends the span, recording err if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
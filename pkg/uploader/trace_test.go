@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSyncerTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	mock := NewMockS3Uploader()
+	mock.ErrorFunc = ErrorNTimes(1, NewRecoverableError())
+	s := newTestSyncer(t, Options{Workers: 1}, mock)
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	spans := map[string][]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+
+	for name, count := range map[string]int{
+		"sync": 1, "cache.load": 1, "hash": 1, "diff": 1, "upload": 1, "S3Uploader.Upload": 3, "retry": 1, "cache.save": 1,
+	} {
+		if len(spans[name]) != count {
+			t.Errorf("Expected %d %s spans, got %d", count, name, len(spans[name]))
+		}
+	}
+
+	root, upload := spans["sync"][0], spans["upload"][0]
+	for _, span := range append(spans["S3Uploader.Upload"], spans["retry"]...) {
+		if span.Parent.SpanID() != upload.SpanContext.SpanID() {
+			t.Errorf("Expected %s to be a child of the upload span", span.Name)
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("Expected %s to be part of the run trace", span.Name)
+		}
+	}
+
+	failed := 0
+	for _, span := range spans["S3Uploader.Upload"] {
+		attrs := map[string]string{}
+		for _, kv := range span.Attributes {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		if attrs["key"] == "" || attrs["size"] == "" || attrs["attempt"] == "" {
+			t.Errorf("Expected the upload span to hold the key, size and attempt, got %v", attrs)
+		}
+		if attrs["status"] == "failed" {
+			failed++
+			if attrs["attempt"] != "1" {
+				t.Error("Expected the first attempt to fail, got", attrs["attempt"])
+			}
+		}
+	}
+	if failed != 1 {
+		t.Error("Expected a single failed attempt, got", failed)
+	}
+}
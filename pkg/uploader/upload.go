@@ -0,0 +1,220 @@
+package uploader
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// max number of attempts to retry a failed upload.
+const maxTries = 10
+
+// S3 errors that we will retry.
+var recoverableErrorsSuffixes = []string{
+	"Idle connections will be closed.",
+	"EOF",
+	"broken pipe",
+	"no such host",
+	"transport closed before response was received",
+	"TLS handshake timeout",
+}
+
+// signature of an s3 uploader func
+type putFunc func(*sourceFile) error
+
+// upload fetches sourceFiles from uploads chan, attempts to upload them and enqueue the results to
+// completed list. On failure it attempts to retry, up to maxTries per source file.
+// The waits before retrying are traced as children of ctx.
+func (s *Syncer) upload(ctx context.Context, fn putFunc, uploads chan *sourceFile, rejected *syncedList, wgUploads, wgWorkers *sync.WaitGroup) {
+	defer wgWorkers.Done()
+
+	for src := range uploads {
+		src := src
+		verb, done := src.verbs()
+
+		if s.opts.DryRun {
+			s.log.Info("Pretending to "+verb, "key", src.key, "bytes", src.bytes())
+			wgUploads.Done()
+			continue
+		}
+
+		start := time.Now()
+		err := fn(src)
+		src.recordAttempt()
+		a := Attempt{Key: src.key, Bytes: src.bytes(), N: src.attempts, Duration: time.Since(start), Err: err}
+		attrs := []any{"key", a.Key, "bytes", a.Bytes, "attempt", a.N, "duration", a.Duration}
+		if err == nil {
+			s.attempted(a)
+			wgUploads.Done()
+			s.log.Info(done, attrs...)
+			continue
+		}
+
+		a.Class, a.Retry = errorClass(err), src.retriable() && isRecoverable(err)
+		s.attempted(a)
+		if !a.Retry {
+			rejected.add(src.fname)
+			s.log.Error("Failed to "+verb, append(attrs, "error", err, "error_class", a.Class)...)
+			s.discardResumable(ctx, src)
+			wgUploads.Done()
+			continue
+		}
+
+		go func() {
+			class, wait := a.Class, s.backoff(src.attempts)
+			s.log.Warn("Retrying", append(attrs, "error", err, "error_class", class)...)
+			_, span := tracer.Start(ctx, "retry", trace.WithAttributes(attribute.String("key", src.key),
+				attribute.Int("attempt", src.attempts), attribute.String("error_class", class),
+				attribute.Int64("wait_ms", wait.Milliseconds())))
+			<-time.After(wait)
+			span.End()
+			uploads <- src
+		}()
+	}
+}
+
+// put uploads the source file to S3, or updates its headers, or deletes it, as the case may be.
+// Each call is traced, named after the S3Uploader method it calls.
+func (s *Syncer) put(ctx context.Context, src *sourceFile) (err error) {
+	if s.up == nil {
+		return fmt.Errorf("s3 uploader is not initialized")
+	}
+	if !src.prevLooked {
+		src.prevVersionID, src.prevExists, src.prevChecked = s.previousVersion(ctx, src.key)
+		src.prevLooked = true
+	}
+
+	ctx, span := tracer.Start(ctx, "S3Uploader."+src.method(), trace.WithAttributes(attribute.String("key", src.key),
+		attribute.Int64("size", src.bytes()), attribute.Int("attempt", src.attempts+1)))
+	defer func() {
+		span.SetAttributes(attribute.String("status", status(err)))
+		endSpan(span, err)
+	}()
+
+	if src.delete {
+		out, err := s.up.Delete(ctx, &DeleteInput{Bucket: s.opts.Bucket, Key: src.key})
+		if err != nil {
+			return err
+		}
+
+		src.versionID = aws.ToString(out.VersionID)
+		return nil
+	}
+
+	if src.headersOnly {
+		out, err := s.up.CopyMetadata(ctx, src.uploadInput(s.opts.Bucket, nil))
+		if err != nil {
+			return err
+		}
+
+		src.recordUpload(out)
+		return nil
+	}
+
+	f, err := os.Open(src.fpath) // #nosec G304 - files from the source folder are expected
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if s.resumable(src) {
+		out, err := s.putResumable(ctx, src, f)
+		if err != nil {
+			return err
+		}
+
+		src.recordUpload(out)
+		return nil
+	}
+
+	var body io.Reader = f
+
+	// Handle gzip compression
+	if src.gzip {
+		pr, pw := io.Pipe()
+		gz := gzip.NewWriter(pw)
+
+		go func() {
+			if _, copyErr := io.Copy(gz, f); copyErr != nil {
+				pw.CloseWithError(fmt.Errorf("compression error: %w", copyErr))
+				return
+			}
+			if closeErr := gz.Close(); closeErr != nil {
+				pw.CloseWithError(fmt.Errorf("gzip close error: %w", closeErr))
+				return
+			}
+			// pw.Close() after successful gz.Close() typically doesn't fail.
+			// If it does, the error will be caught by the Upload call.
+			if closeErr := pw.Close(); closeErr != nil {
+				// Can't call CloseWithError after Close, error will surface in Upload
+				return
+			}
+		}()
+
+		body = pr
+	}
+
+	out, err := s.up.Upload(ctx, src.uploadInput(s.opts.Bucket, body))
+	if err != nil {
+		return err
+	}
+
+	src.recordUpload(out)
+	return nil
+}
+
+// isRecoverable verifies if the error given is in recoverableErrorsSuffixes list.
+func isRecoverable(err error) bool {
+	for _, errSuffix := range recoverableErrorsSuffixes {
+		if strings.HasSuffix(err.Error(), errSuffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// status describes the outcome of an attempt, for tracing.
+func status(err error) string {
+	if err != nil {
+		return "failed"
+	}
+
+	return "ok"
+}
+
+// attempted reports the attempt a to Options.OnAttempt, if set.
+func (s *Syncer) attempted(a Attempt) {
+	if s.opts.OnAttempt != nil {
+		s.opts.OnAttempt(a)
+	}
+}
+
+// errorClass classifies the error, for logs and metrics: the S3 error code if there is one,
+// "network" for the other recoverable errors and "other" for the rest.
+func errorClass(err error) string {
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.ErrorCode()
+	case isRecoverable(err):
+		return "network"
+	default:
+		return "other"
+	}
+}
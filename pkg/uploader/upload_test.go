@@ -0,0 +1,232 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+const (
+	_ = iota
+	noError
+	recoverableError
+	fatalError
+)
+
+func fakeUploaderGen(opts ...int) (putFunc, *[]*sourceFile) {
+	errorKind, m := noError, sync.Mutex{}
+	if len(opts) > 0 {
+		errorKind = opts[0]
+	}
+
+	out := &[]*sourceFile{}
+	fn := func(src *sourceFile) error {
+		m.Lock()
+		*out = append(*out, src)
+		m.Unlock()
+
+		switch errorKind {
+		case noError:
+			return nil
+		case recoverableError:
+			return errors.New("Something something. " + recoverableErrorsSuffixes[0])
+		default:
+			return errors.New("Some made up error")
+		}
+	}
+
+	return fn, out
+}
+
+func TestUpload(t *testing.T) {
+	s := newTestSyncer(t, Options{}, nil)
+	upFn, uploads := fakeUploaderGen()
+	up := make(chan *sourceFile)
+	rejected := &syncedList{}
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+
+	wgUploads.Add(2)
+	wgWorkers.Add(1)
+
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+
+	up <- newSourceFile(&s.opts, "foobar.html")
+	up <- newSourceFile(&s.opts, "barbaz.txt")
+
+	wgUploads.Wait()
+	close(up)
+	wgWorkers.Wait()
+
+	if len(*uploads) != 2 {
+		t.Fatal("Expected to upload 2 files, got", *uploads)
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	s := newTestSyncer(t, Options{DryRun: true}, nil)
+	upFn, uploads := fakeUploaderGen()
+	up := make(chan *sourceFile)
+	rejected := &syncedList{}
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+
+	wgUploads.Add(2)
+	wgWorkers.Add(1)
+
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+
+	up <- newSourceFile(&s.opts, "foobar.html")
+	up <- newSourceFile(&s.opts, "barbaz.txt")
+
+	wgUploads.Wait()
+	close(up)
+	wgWorkers.Wait()
+
+	if len(*uploads) > 0 {
+		t.Fatal("Expected to get a blank uploads list, got", *uploads)
+	}
+}
+
+func TestUploadUnrecoverable(t *testing.T) {
+	s := newTestSyncer(t, Options{}, nil)
+	upFn, uploads := fakeUploaderGen(fatalError)
+	up := make(chan *sourceFile)
+	rejected := &syncedList{}
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+
+	wgUploads.Add(2)
+	wgWorkers.Add(1)
+
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+
+	up <- newSourceFile(&s.opts, "foobar.html")
+	up <- newSourceFile(&s.opts, "barbaz.txt")
+
+	wgUploads.Wait()
+	close(up)
+	wgWorkers.Wait()
+
+	if len(*uploads) != 2 {
+		t.Fatal("Expected both uploads to be processed, got", *uploads)
+	}
+	if len(rejected.list) != 2 {
+		t.Fatal("Expected all of the uploads to be rejected, got", rejected.list)
+	}
+}
+
+func TestUploadRecoverable(t *testing.T) {
+	s := newTestSyncer(t, Options{}, nil)
+	upFn, uploads := fakeUploaderGen(recoverableError)
+	up := make(chan *sourceFile)
+	rejected := &syncedList{}
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+
+	wgUploads.Add(2)
+	wgWorkers.Add(2)
+
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+
+	sf1, sf2 := newSourceFile(&s.opts, "barbaz.txt"), newSourceFile(&s.opts, "foobar.html")
+	up <- sf1
+	up <- sf2
+
+	wgUploads.Wait()
+	close(up)
+	wgWorkers.Wait()
+
+	if lu := len(*uploads); lu != 2*maxTries {
+		t.Fatal("Expected both uploads to be processed maxTries, got", lu, "attempts")
+	}
+	if sf1.attempts != maxTries || sf2.attempts != maxTries {
+		t.Fatal("Expected both files to have their attempts exhausted got", sf1.attempts, "and", sf2.attempts)
+	}
+	if len(rejected.list) != 2 {
+		t.Fatal("Expected all of the uploads to be rejected, got", rejected.list)
+	}
+}
+
+func TestUploadOnAttempt(t *testing.T) {
+	var attempts []Attempt
+	m := sync.Mutex{}
+	s := newTestSyncer(t, Options{OnAttempt: func(a Attempt) {
+		m.Lock()
+		attempts = append(attempts, a)
+		m.Unlock()
+	}}, nil)
+	upFn, _ := fakeUploaderGen(recoverableError)
+	up := make(chan *sourceFile)
+	rejected := &syncedList{}
+	wgUploads, wgWorkers := new(sync.WaitGroup), new(sync.WaitGroup)
+
+	wgUploads.Add(1)
+	wgWorkers.Add(1)
+
+	go s.upload(context.Background(), upFn, up, rejected, wgUploads, wgWorkers)
+
+	up <- newSourceFile(&s.opts, "barbaz.txt")
+
+	wgUploads.Wait()
+	close(up)
+	wgWorkers.Wait()
+
+	if len(attempts) != maxTries {
+		t.Fatal("Expected every attempt to be reported, got", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Key != "barbaz.txt" || a.N != i+1 || a.Err == nil || a.Class != "network" || a.Retry != (i < maxTries-1) {
+			t.Errorf("Unexpected attempt %d: %+v", i, a)
+		}
+	}
+}
+
+func TestPutHeadersOnly(t *testing.T) {
+	mock := NewMockS3Uploader()
+	s := newTestSyncer(t, Options{}, mock)
+
+	src := newSourceFile(&s.opts, testHTMLFile)
+	src.headersOnly = true
+	if err := s.put(context.Background(), src); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	if len(mock.Uploads) != 0 || len(mock.Copies) != 1 {
+		t.Fatalf("Expected a single metadata copy and no uploads, got %d uploads and %d copies",
+			len(mock.Uploads), len(mock.Copies))
+	}
+
+	in := mock.Copies[0].Input
+	if in.Key != testHTMLFile || in.CacheControl == nil || *in.CacheControl != "max-age=3600" {
+		t.Errorf("Unexpected copy input %+v", in)
+	}
+	if src.etag != "\"mock-etag\"" || src.uploadedAt.IsZero() {
+		t.Error("Expected the copy result to be recorded on the source file")
+	}
+}
+
+func TestIsRecoverable(t *testing.T) {
+	if msg := "broken pipes all over"; isRecoverable(errors.New(msg)) {
+		t.Errorf("Expected %s to NOT be recoverable", msg)
+	}
+
+	if msg := "Oh noes, I broken pipe"; !isRecoverable(errors.New(msg)) {
+		t.Errorf("Expected %s to BE recoverable", msg)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	testCases := map[string]error{
+		"network":      NewNetworkError(),
+		"other":        NewAccessDeniedError(),
+		"AccessDenied": fmt.Errorf("upload: %w", &smithy.GenericAPIError{Code: "AccessDenied"}),
+	}
+
+	for expected, err := range testCases {
+		if actual := errorClass(err); actual != expected {
+			t.Errorf("Expected %v to be classified as %s, got %s", err, expected, actual)
+		}
+	}
+}
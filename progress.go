@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+const (
+	// progressInterval is how often the progress line is redrawn, at most.
+	progressInterval = 100 * time.Millisecond
+	// progressWindow is how far back the current throughput is measured.
+	progressWindow = 5 * time.Second
+	// progressBarWidth is the number of characters of the progress bar.
+	progressBarWidth = 20
+)
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressHandler shows a live progress line, meant for terminals: the files done out of the total,
+// the bytes sent, the current throughput, the retries, the failures and the ETA. The totals come
+// from the "Syncing" record, the progress from the records about a file (those with a key).
+// Warnings and errors not about a file are printed above the progress line.
+type progressHandler struct {
+	level  slog.Leveler
+	prefix string // "<target>: ", from the target attribute
+	*progressState
+}
+
+// progressState is shared by a progressHandler and all the handlers derived from it.
+type progressState struct {
+	sync.Mutex
+	w   io.Writer
+	now func() time.Time
+
+	active            bool // a run is in progress, its line is on screen
+	start, drawn      time.Time
+	files, totalFiles int
+	bytes, totalBytes int64
+	retries, failures int
+	samples           []progressSample
+}
+
+// progressSample is the number of bytes sent by a point in time, for measuring the throughput.
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func newProgressHandler(w io.Writer, level slog.Leveler) *progressHandler {
+	return &progressHandler{level: level, progressState: &progressState{w: w, now: time.Now}}
+}
+
+func (h *progressHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *progressHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := attrsOf(r)
+
+	h.Lock()
+	defer h.Unlock()
+
+	now, out, force := h.now(), "", false
+	switch {
+	case attrs.str("key") != "":
+		switch {
+		case r.Level >= slog.LevelError:
+			h.files++
+			h.failures++
+			out = h.above(h.prefix + r.Message + " " + attrs.str("key") + attrs.errSuffix())
+		case r.Level >= slog.LevelWarn:
+			h.retries++
+		default:
+			h.files++
+			h.bytes += attrs.int("bytes")
+		}
+	case r.Message == "Syncing":
+		h.reset(now)
+		h.totalFiles = int(attrs.int("upload") + attrs.int("update_headers") + attrs.int("delete"))
+		h.totalBytes = attrs.int("bytes")
+		force = true
+	case r.Message == "All done":
+		if !h.active {
+			return nil
+		}
+		out, h.active = h.line(now)+"\n", false
+		_, err := io.WriteString(h.w, out)
+		return err
+	case r.Message == "Nothing to upload":
+		out = h.above(h.prefix + "Nothing to upload.")
+	case r.Level >= slog.LevelWarn:
+		out = h.above(h.prefix + r.Message + attrs.errSuffix())
+	}
+
+	if h.active && (force || out != "" || now.Sub(h.drawn) >= progressInterval) {
+		h.samples = append(h.samples, progressSample{now, h.bytes})
+		out += h.line(now)
+		h.drawn = now
+	}
+	_, err := io.WriteString(h.w, out)
+
+	return err
+}
+
+// reset starts tracking a new run.
+func (s *progressState) reset(now time.Time) {
+	s.active, s.start, s.drawn = true, now, time.Time{}
+	s.files, s.bytes, s.retries, s.failures, s.samples = 0, 0, 0, 0, nil
+}
+
+// above returns msg as a line printed over the progress line, which is redrawn below it.
+func (h *progressHandler) above(msg string) string {
+	if h.active {
+		return "\r\033[K" + msg + "\n"
+	}
+
+	return msg + "\n"
+}
+
+// line returns the progress line, to be drawn over the current one.
+func (h *progressHandler) line(now time.Time) string {
+	done := 1.0
+	if h.totalFiles > 0 {
+		done = float64(h.files) / float64(h.totalFiles)
+	}
+	filled := int(done * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	return fmt.Sprintf("\r\033[K%s[%s] %d/%d files, %s/%s, %s/s, %d retries, %d failed, ETA %s",
+		h.prefix, bar, h.files, h.totalFiles, uploader.FormatBytes(h.bytes), uploader.FormatBytes(h.totalBytes),
+		uploader.FormatBytes(int64(h.throughput(now))), h.retries, h.failures, h.eta(now))
+}
+
+// throughput returns the bytes sent per second, over the last progressWindow.
+func (s *progressState) throughput(now time.Time) float64 {
+	for len(s.samples) > 1 && now.Sub(s.samples[0].at) > progressWindow {
+		s.samples = s.samples[1:]
+	}
+
+	first := progressSample{s.start, 0}
+	if len(s.samples) > 1 {
+		first = s.samples[0]
+	}
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.bytes-first.bytes) / elapsed
+}
+
+// eta estimates the time left, from the bytes left if known, from the files left otherwise.
+func (s *progressState) eta(now time.Time) string {
+	var left time.Duration
+	switch rate := s.throughput(now); {
+	case s.files >= s.totalFiles:
+	case s.totalBytes > 0 && rate > 0:
+		left = time.Duration(float64(s.totalBytes-s.bytes) / rate * float64(time.Second))
+	case s.files > 0:
+		left = now.Sub(s.start) / time.Duration(s.files) * time.Duration(s.totalFiles-s.files)
+	default:
+		return "?"
+	}
+
+	return left.Round(time.Second).String()
+}
+
+func (h *progressHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	for _, a := range attrs {
+		if a.Key == "target" {
+			h2.prefix = a.Value.String() + ": "
+		}
+	}
+
+	return &h2
+}
+
+func (h *progressHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
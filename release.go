@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+// releaseIDFormat is the format of the default release IDs, the UTC time of the upload.
+const releaseIDFormat = "20060102T150405Z"
+
+// emptyCacheStore is the cache of a release: always empty, so that every file is uploaded under
+// the prefix of the release, and never saved.
+type emptyCacheStore struct{}
+
+func (emptyCacheStore) Load(_ context.Context) (uploader.FileCache, error) {
+	return uploader.FileCache{}, nil
+}
+
+func (emptyCacheStore) Save(_ context.Context, _ uploader.FileCache) error {
+	return nil
+}
+
+// newReleases returns the releases of the bucket, as configured by opts.
+func newReleases(opts *options, up uploader.S3Uploader) (*uploader.Releases, error) {
+	if opts.BucketName == "" {
+		return nil, fmt.Errorf("bucket name is not set")
+	}
+	if up == nil {
+		return nil, fmt.Errorf("s3 uploader is not initialized")
+	}
+
+	var pointers []string
+	for _, p := range strings.Split(opts.ReleasePointers, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			pointers = append(pointers, p)
+		}
+	}
+
+	rels := uploader.NewReleases(up, opts.BucketName, opts.ReleasePrefix, pointers)
+	rels.Encrypt = opts.Encrypt
+
+	return rels, nil
+}
+
+// runRelease uploads the source folder as a new release, under its own prefix, then promotes it
+// and prunes the old releases (see publishRelease). The release ID is the command argument, the
+// current time if none is given.
+func runRelease() int {
+	id := time.Now().UTC().Format(releaseIDFormat)
+	if len(opts.args) > 0 {
+		id = opts.args[0]
+	}
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		fmt.Printf("Invalid release ID %q.\n", id)
+		return CmdLineOptionError
+	}
+
+	opts.release, opts.DoCache = id, false
+	return run()
+}
+
+// publishRelease records the release just uploaded, promotes it and prunes the old releases, keeping
+// opts.ReleaseKeep of them. The keys of the pointers switched to the release are added to those of
+// res, to be invalidated. It is only called once every file of the release is uploaded.
+func publishRelease(ctx context.Context, res *uploader.Result) error {
+	if opts.DryRun {
+		logger.Info("Pretending to promote release", "release", opts.release)
+		return nil
+	}
+
+	rels, err := newReleases(opts, s3Uploader)
+	if err != nil {
+		return err
+	}
+	rel := &uploader.Release{ID: opts.release, CreatedAt: time.Now().UTC(), Version: Version, Keys: res.Keys}
+	if err := rels.Add(ctx, rel); err != nil {
+		return err
+	}
+
+	pointers, err := rels.Promote(ctx, opts.release)
+	if err != nil {
+		return err
+	}
+	logger.Info("Promoted release", "release", opts.release)
+	res.Keys = append(res.Keys, pointers...)
+
+	pruned, err := rels.Prune(ctx, opts.ReleaseKeep)
+	if len(pruned) > 0 {
+		logger.Info("Pruned releases", "releases", strings.Join(pruned, ","))
+	}
+
+	return err
+}
+
+// runReleaseList prints the releases, oldest first.
+func runReleaseList() int {
+	rels, err := newReleases(opts, s3Uploader)
+	if err != nil {
+		fmt.Println("Release failed: ", err)
+		return ReleaseFailure
+	}
+	idx, err := rels.Load(context.Background())
+	if err != nil {
+		fmt.Println("Release failed: ", err)
+		return ReleaseFailure
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tFILES\tVERSION\tCURRENT")
+	for _, rel := range idx.Releases {
+		current := ""
+		if rel.ID == idx.Current {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", rel.ID, formatTime(rel.CreatedAt), len(rel.Keys), rel.Version, current)
+	}
+	if err = tw.Flush(); err != nil {
+		fmt.Println(err)
+		return ReleaseFailure
+	}
+
+	return Success
+}
+
+// runReleasePromote makes the release given as the command argument live.
+func runReleasePromote() int {
+	if len(opts.args) == 0 {
+		fmt.Println("Release failed: no release ID given")
+		return CmdLineOptionError
+	}
+
+	return switchRelease(func(ctx context.Context, rels *uploader.Releases) (string, []string, error) {
+		keys, err := rels.Promote(ctx, opts.args[0])
+		return opts.args[0], keys, err
+	})
+}
+
+// runReleaseRollback makes the release before the current one live.
+func runReleaseRollback() int {
+	return switchRelease(func(ctx context.Context, rels *uploader.Releases) (string, []string, error) {
+		return rels.Rollback(ctx)
+	})
+}
+
+// switchRelease switches the live release with fn, which returns the ID of the new one and the
+// keys of its pointers, then invalidates them in CloudFront.
+func switchRelease(fn func(context.Context, *uploader.Releases) (string, []string, error)) int {
+	ctx := context.Background()
+	rels, err := newReleases(opts, s3Uploader)
+	if err != nil {
+		fmt.Println("Release failed: ", err)
+		return ReleaseFailure
+	}
+
+	id, keys, err := fn(ctx, rels)
+	if err != nil {
+		fmt.Println("Release failed: ", err)
+		return ReleaseFailure
+	}
+	fmt.Printf("Release %s is live.\n", id)
+
+	if err := invalidate(ctx, &uploader.Result{Keys: keys}); err != nil {
+		fmt.Println("Invalidating CloudFront failed: ", err)
+		return InvalidationFailure
+	}
+
+	return Success
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestRunRelease(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	cacheFile := filepath.Join(t.TempDir(), "cache.txt")
+	opts.CacheFile, opts.ReleasePointers, opts.ReleaseKeep = cacheFile, "foobar.html", 2
+	base := *opts
+
+	// Each release uploads everything under its own prefix, whatever the cache says.
+	for _, id := range []string{"r1", "r2", "r3"} {
+		*opts = base
+		opts.args = []string{id}
+		if code := runRelease(); code != Success {
+			t.Fatalf("Expected release %s to succeed, got %d", id, code)
+		}
+		if mock.Objects[opts.BucketName+"/releases/"+id+"/barbaz.txt"] == nil {
+			t.Fatalf("Expected release %s to be uploaded", id)
+		}
+	}
+	if _, err := os.Stat(cacheFile); !os.IsNotExist(err) {
+		t.Error("Expected the cache to be left alone")
+	}
+	if mock.Objects[opts.BucketName+"/releases/r1/barbaz.txt"] != nil {
+		t.Error("Expected the oldest release to be pruned")
+	}
+
+	*opts = base
+	if code := runReleaseRollback(); code != Success {
+		t.Fatal("Expected the rollback to succeed, got", code)
+	}
+	idx, err := uploader.NewReleases(mock, opts.BucketName, opts.ReleasePrefix, nil).Load(context.Background())
+	if err != nil || idx.Current != "r2" || len(idx.Releases) != 2 {
+		t.Fatalf("Expected r2 to be live, got %+v %v", idx, err)
+	}
+
+	opts.args = []string{"r3"}
+	if code := runReleasePromote(); code != Success {
+		t.Fatal("Expected the promotion to succeed, got", code)
+	}
+	live, r3 := mock.Objects[opts.BucketName+"/foobar.html"], mock.Objects[opts.BucketName+"/releases/r3/foobar.html"]
+	if live == nil || live.ETag != r3.ETag {
+		t.Error("Expected the pointer of r3 to be live")
+	}
+
+	opts.args = []string{"r1"}
+	if code := runReleasePromote(); code != ReleaseFailure {
+		t.Error("Expected promoting a pruned release to fail, got", code)
+	}
+}
+
+func TestRunReleasePartial(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	mock.ErrorFunc = func(input *uploader.UploadInput) error {
+		if strings.HasSuffix(input.Key, "barbaz.txt") {
+			return errors.New("access denied")
+		}
+		return nil
+	}
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	opts.CacheFile, opts.ReleasePointers = filepath.Join(t.TempDir(), "cache.txt"), "foobar.html"
+	opts.args = []string{"r1"}
+	if code := runRelease(); code != UploadFailure {
+		t.Fatal("Expected a partial release to fail, got", code)
+	}
+	if mock.Objects[opts.BucketName+"/foobar.html"] != nil {
+		t.Error("Expected the partial release not to be made live")
+	}
+	if idx, err := uploader.NewReleases(mock, opts.BucketName, opts.ReleasePrefix, nil).Load(context.Background()); err != nil || len(idx.Releases) != 0 {
+		t.Errorf("Expected the partial release not to be recorded, got %+v %v", idx, err)
+	}
+}
+
+func TestRunReleaseRecordFails(t *testing.T) {
+	saved := *opts
+	defer func() { *opts = saved }()
+	mock := uploader.NewMockS3Uploader()
+	s3Uploader = mock
+	defer func() { s3Uploader = nil }()
+
+	// The run manifests cannot be saved under a regular file.
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts.CacheFile, opts.ReleasePointers = filepath.Join(dir, "cache.txt"), "foobar.html"
+	opts.RunManifests = filepath.Join(blocker, "runs")
+	opts.args = []string{"r1"}
+
+	if code := runRelease(); code != Success {
+		t.Fatal("Expected the release to succeed even though it could not be recorded, got", code)
+	}
+	if mock.Objects[opts.BucketName+"/foobar.html"] == nil {
+		t.Error("Expected the release to be made live")
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
-	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
 )
 
 // isTestMode checks if the program is running under go test
@@ -24,14 +27,21 @@ func isTestMode() bool {
 }
 
 var opts = &options{
-	WorkersCount: runtime.NumCPU() * 2,
-	Source:       "output",
-	CacheFile:    ".go-s3-uploader.txt",
-	doUpload:     true,
-	doCache:      true,
-	Region:       os.Getenv("AWS_DEFAULT_REGION"),
-	Profile:      os.Getenv("AWS_DEFAULT_PROFILE"),
-	cfgFile:      ".go-s3-uploader.json",
+	WorkersCount:        runtime.NumCPU() * 2,
+	Source:              "output",
+	CacheFile:           ".go-s3-uploader.txt",
+	DoUpload:            true,
+	DoCache:             true,
+	Resume:              true,
+	LockTimeout:         duration(30 * time.Second),
+	MultipartStaleAfter: duration(24 * time.Hour),
+	CloudFrontMaxPaths:  15,
+	ReleasePrefix:       "releases/",
+	ReleasePointers:     "index.html",
+	ReleaseKeep:         5,
+	Region:              os.Getenv("AWS_DEFAULT_REGION"),
+	Profile:             os.Getenv("AWS_DEFAULT_PROFILE"),
+	cfgFile:             ".go-s3-uploader.json",
 }
 
 var appEnv string
@@ -39,42 +49,76 @@ var appEnv string
 // s3Uploader is the global S3 uploader instance.
 // In production, this is initialized by initAWSClient().
 // In tests, this can be replaced with a mock.
-var s3Uploader S3Uploader
-
-var say func(...string)
-
-// Order matters: first hit, first served.
-// TODO: Make this configurable somehow, so that end users can provide their own mappings.
-var r = regexp.MustCompile
-var customHeadersDef = []pathToHeaders{
-	{r("index\\.html"), headers{ContentEncoding: "gzip", CacheControl: "max-age=1800"}},       // 1800
-	{r("articole.*\\.html$"), headers{ContentEncoding: "gzip", CacheControl: "max-age=3600"}}, // 86400
-	{r("[^/]*\\.html$"), headers{ContentEncoding: "gzip", CacheControl: "max-age=3600"}},
-	{r("\\.xml$"), headers{ContentEncoding: "gzip", CacheControl: "max-age=1800"}},
-	{r("\\.ico$"), headers{ContentEncoding: "gzip", CacheControl: "max-age=31536000"}},
-	{r("\\.(js|css)$"), headers{ContentEncoding: "gzip", CacheControl: "max-age=31536000"}},
-	{r("images/articole/.*(jpg|JPG|png|PNG)$"), headers{CacheControl: "max-age=31536000"}},
-	{r("\\.(jpg|JPG|png|PNG)$"), headers{CacheControl: "max-age=31536000"}},
+var s3Uploader uploader.S3Uploader
+
+// cdn invalidates the CloudFront distribution, if any. Like s3Uploader, tests can replace it.
+var cdn uploader.CloudFrontInvalidator
+
+// processCmdLineFlags wraps the command line handling: it picks the subcommand, then layers the
+// defaults, config file, environment and command line flags into opts and returns where each
+// setting came from.
+func processCmdLineFlags(opts *options) (configSources, error) {
+	cmd, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	opts.command = cmd
+	flag.Usage = usage
+
+	sources, err := loadConfig(flag.CommandLine, opts, args, os.LookupEnv)
+	opts.args = flag.CommandLine.Args()
+
+	return sources, err
 }
 
-// processCmdLineFlags wraps the command line flags handling.
-func processCmdLineFlags(opts *options) {
-	flag.IntVar(&opts.WorkersCount, "workers", opts.WorkersCount, "No. of workers to use for uploads")
-	flag.StringVar(&opts.BucketName, "bucket", opts.BucketName, "Bucket to upload files to")
-	flag.StringVar(&opts.Source, "source", opts.Source, "Source folder for files to be uploaded")
-	flag.StringVar(&opts.CacheFile, "cachefile", opts.CacheFile, "Location of the cache file")
-	flag.StringVar(&opts.Region, "region", opts.Region, "AWS region")
-	flag.StringVar(&opts.Profile, "profile", opts.Profile, "AWS shared profile")
-	flag.StringVar(&opts.cfgFile, "cfgfile", opts.cfgFile, "Config file location")
-	flag.BoolVar(&opts.dryRun, "dry", opts.dryRun, "Dry run (do not upload/update cache)")
-	flag.BoolVar(&opts.verbose, "verbose", opts.verbose, "Print the name of the files as they are uploaded")
-	flag.BoolVar(&opts.quiet, "quiet", opts.quiet, "Print only warnings and/or errors")
-	flag.BoolVar(&opts.doUpload, "upload", opts.doUpload, "Do perform an upload")
-	flag.BoolVar(&opts.doCache, "cache", opts.doCache, "Do update the cache")
-	flag.BoolVar(&opts.Encrypt, "encrypt", opts.Encrypt, "Encrypt files on server side")
-	flag.BoolVar(&opts.saveCfg, "save", opts.saveCfg, "Saves the current commandline options to a config file")
-	flag.BoolVar(&opts.version, "version", opts.version, "Print version information and exit")
-	flag.Parse()
+// defineFlags defines the command line flags on fs, bound to the opts fields.
+// Each of them can also be set with an environment variable, see envName.
+func defineFlags(fs *flag.FlagSet, opts *options) {
+	fs.IntVar(&opts.WorkersCount, "workers", opts.WorkersCount, "No. of workers to use for uploads (0 for twice the number of CPUs)")
+	fs.StringVar(&opts.BucketName, "bucket", opts.BucketName, "Bucket to upload files to")
+	fs.StringVar(&opts.Source, "source", opts.Source, "Source folder for files to be uploaded")
+	fs.StringVar(&opts.CacheFile, "cachefile", opts.CacheFile, "Location of the cache file")
+	fs.StringVar(&opts.CacheRemote, "cache-remote", opts.CacheRemote, "Keep the cache in S3 instead: a key in the target bucket or s3://bucket/key")
+	fs.StringVar(&opts.Region, "region", opts.Region, "AWS region")
+	fs.StringVar(&opts.Profile, "profile", opts.Profile, "AWS shared profile")
+	fs.Var(&opts.LockTimeout, "lock-timeout", "How long to wait for another run holding the cache lock")
+	fs.IntVar(&opts.PartSize, "part-size", opts.PartSize, "Size of the parts of multipart uploads, in MiB (0 for the SDK default, 5)")
+	fs.IntVar(&opts.PartConcurrency, "part-concurrency", opts.PartConcurrency, "No. of parts of a file uploaded in parallel (0 for the SDK default, 5)")
+	fs.BoolVar(&opts.LeavePartsOnError, "leave-parts-on-error", opts.LeavePartsOnError, "Leave the parts of failed multipart uploads in the bucket instead of aborting them, for -resume to resume them")
+	fs.BoolVar(&opts.Resume, "resume", opts.Resume, "Resume the interrupted uploads of large files where they stopped, on the next attempt or run")
+	fs.Var(&opts.MultipartStaleAfter, "multipart-stale-after", "Age after which multipart cleanup aborts incomplete multipart uploads")
+	fs.StringVar(&opts.cfgFile, "cfgfile", opts.cfgFile, "Config file location")
+	fs.StringVar(&opts.planFile, "plan", opts.planFile, "Plan file, written by plan and carried out by apply")
+	fs.StringVar(&opts.Target, "target", opts.Target, "Comma separated list of config file targets to upload to, or \"all\"")
+	fs.BoolVar(&opts.DryRun, "dry", opts.DryRun, "Dry run (do not upload/update cache)")
+	fs.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "Log every file as it is uploaded, instead of the compact progress")
+	fs.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "Print only warnings and/or errors")
+	fs.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "Log level: debug, info, warn or error (info by default, warn with -quiet)")
+	fs.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "Log format: progress, compact, text or json (progress on a terminal, compact otherwise, text with -verbose)")
+	fs.StringVar(&opts.LogFile, "log-file", opts.LogFile, "Also append the log to this file, as JSON unless -log-format=text")
+	fs.StringVar(&opts.MetricsAddr, "metrics-addr", opts.MetricsAddr, "Serve Prometheus metrics on this address (e.g. :9090) during the run")
+	fs.StringVar(&opts.MetricsFile, "metrics-file", opts.MetricsFile, "Write Prometheus metrics to this file at the end, for the node exporter textfile collector")
+	fs.StringVar(&opts.MetricsPush, "metrics-push", opts.MetricsPush, "Push Prometheus metrics to this push gateway URL at the end")
+	fs.StringVar(&opts.TraceOTLP, "trace-otlp", opts.TraceOTLP, "Export OpenTelemetry traces over OTLP/HTTP to this endpoint (e.g. http://localhost:4318)")
+	fs.StringVar(&opts.TraceFile, "trace-file", opts.TraceFile, "Append OpenTelemetry traces to this file, as JSON lines")
+	fs.StringVar(&opts.CloudFrontDistribution, "cloudfront-distribution", opts.CloudFrontDistribution, "CloudFront distribution ID to invalidate the uploaded and deleted files in")
+	fs.IntVar(&opts.CloudFrontMaxPaths, "cloudfront-max-paths", opts.CloudFrontMaxPaths, "Collapse the invalidated paths into wildcards above this many")
+	fs.BoolVar(&opts.CloudFrontWait, "cloudfront-wait", opts.CloudFrontWait, "Wait for the CloudFront invalidation to complete")
+	fs.StringVar(&opts.NotifyURL, "notify-url", opts.NotifyURL, "POST the result of each run to this webhook URL")
+	fs.StringVar(&opts.NotifyFormat, "notify-format", opts.NotifyFormat, "Format of the notifications: generic (JSON) or slack")
+	fs.StringVar(&opts.ReleasePrefix, "release-prefix", opts.ReleasePrefix, "Prefix the releases are uploaded under, each in its own folder")
+	fs.StringVar(&opts.ReleasePointers, "release-pointers", opts.ReleasePointers, "Comma separated files copied from a release to the root of the bucket to make it live")
+	fs.IntVar(&opts.ReleaseKeep, "release-keep", opts.ReleaseKeep, "Number of releases to keep, 0 for all")
+	fs.StringVar(&opts.RunManifests, "run-manifests", opts.RunManifests, "Save the object versions changed by each run to this folder (or s3://bucket/prefix), for rollback")
+	fs.BoolVar(&opts.DoUpload, "upload", opts.DoUpload, "Do perform an upload")
+	fs.BoolVar(&opts.DoCache, "cache", opts.DoCache, "Do update the cache")
+	fs.BoolVar(&opts.Encrypt, "encrypt", opts.Encrypt, "Encrypt files on server side")
+	fs.BoolVar(&opts.CopyHeaders, "copy-headers", opts.CopyHeaders, "Update the headers of files whose content did not change in place, without re-uploading them")
+	fs.BoolVar(&opts.Delete, "delete", opts.Delete, "Delete the objects of the files removed from the source folder")
+	fs.BoolVar(&opts.Paranoid, "paranoid", opts.Paranoid, "Hash every file, even if its size and mtime did not change")
+	fs.BoolVar(&opts.saveCfg, "save", opts.saveCfg, "Saves the current commandline options to a config file")
+	fs.BoolVar(&opts.printCfg, "print-config", opts.printCfg, "Print the effective configuration, and where each value came from, then exit")
+	fs.BoolVar(&opts.version, "version", opts.version, "Print version information and exit")
 }
 
 // validateCmdLineFlags validates some of the flags, mostly paths. Defers actual validation to validateCmdLineFlag()
@@ -84,6 +128,18 @@ func validateCmdLineFlags(opts *options) error {
 		"Source":      opts.Source,
 		"Cache file":  opts.CacheFile,
 	}
+	if opts.WorkersCount < 0 {
+		return fmt.Errorf("workers count cannot be negative")
+	}
+	if opts.PartSize != 0 && (opts.PartSize < 5 || opts.PartSize > 5120) {
+		return fmt.Errorf("part size must be between 5 and 5120 MiB")
+	}
+	if opts.PartConcurrency < 0 {
+		return fmt.Errorf("part concurrency cannot be negative")
+	}
+	if f := opts.NotifyFormat; f != "" && f != notifyGeneric && f != notifySlack {
+		return fmt.Errorf("unknown notification format %q", f)
+	}
 	for label, val := range flags {
 		if err := validateCmdLineFlag(label, val); err != nil {
 			return err
@@ -146,45 +202,84 @@ func initAWSClient() {
 	}
 
 	// Create the S3 uploader
-	s3Uploader = NewS3Uploader(&cfg)
+	s3Uploader = uploader.NewS3Uploader(&cfg, opts.multipart)
+	if opts.CloudFrontDistribution != "" {
+		cdn = uploader.NewCloudFrontInvalidator(&cfg)
+	}
+}
+
+// setupMetrics turns the run metrics on if any of the metrics options is set, serving them on
+// o.MetricsAddr if set. The syncers report to them (see options.syncOptions).
+func setupMetrics(o *options) error {
+	if o.MetricsAddr == "" && o.MetricsFile == "" && o.MetricsPush == "" {
+		return nil
+	}
+
+	metrics = newRunMetrics()
+	if o.MetricsAddr != "" {
+		return metrics.serve(o.MetricsAddr)
+	}
+
+	return nil
 }
 
 func abort(msg error) {
-	say(msg.Error())
+	logger.Error(msg.Error())
 	os.Exit(SetupFailed)
 }
 
 func init() {
 	// Skip full initialization in test mode - tests will set up their own mocks
+	logger = slog.New(newCompactHandler(os.Stdout, slog.LevelInfo))
 	if isTestMode() {
 		appEnv = testEnv
-		say = loggerGen()
 		return
 	}
 
-	oldCfgFile := opts.cfgFile
-	if err := opts.restore(opts.cfgFile); err != nil {
-		abort(err)
+	sources, err := processCmdLineFlags(opts)
+	if err != nil {
+		fmt.Printf("%v.\n\n", err)
+		usage()
+		os.Exit(CmdLineOptionError)
 	}
-	processCmdLineFlags(opts)
 
-	// Handle version flag early, before AWS initialization
-	if opts.version {
+	// Handle the commands that do not need a target early, before AWS initialization.
+	// The -version, -print-config and -save flags are kept as aliases of the respective commands,
+	// except that -save goes on with the sync afterwards.
+	if opts.version || opts.command == cmdVersion {
 		fmt.Println(GetVersion())
 		os.Exit(Success)
 	}
 
-	if opts.cfgFile != oldCfgFile { // we were given a different config file, use that instead.
-		if err := opts.restore(opts.cfgFile); err != nil {
+	if opts.printCfg || opts.command == cmdConfigShow {
+		if err := showConfig(os.Stdout, flag.CommandLine, opts, sources); err != nil {
 			abort(err)
 		}
+		os.Exit(Success)
 	}
-	if opts.saveCfg {
+
+	if opts.saveCfg || opts.command == cmdConfigSave {
 		if err := opts.dump(opts.cfgFile); err != nil {
 			abort(err)
 		}
+		if opts.command == cmdConfigSave {
+			fmt.Printf("Saved config to %s\n", opts.cfgFile)
+			os.Exit(Success)
+		}
+	}
+	if err := opts.compileHeaders(); err != nil {
+		abort(err)
+	}
+	if logger, err = newLogger(opts, os.Stdout); err != nil {
+		fmt.Printf("%v.\n\n", err)
+		usage()
+		os.Exit(CmdLineOptionError)
+	}
+	if err := setupMetrics(opts); err != nil {
+		abort(err)
+	}
+	if err := setupTracing(opts); err != nil {
+		abort(err)
 	}
 	appEnv = "production"
-	say = loggerGen()
-	initAWSClient()
 }
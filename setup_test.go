@@ -2,18 +2,10 @@ package main
 
 import (
 	"bytes"
-	"errors"
-	"sync"
+	"log/slog"
 	"testing"
 )
 
-const (
-	_ = iota
-	noError
-	recoverableError
-	fatalError
-)
-
 func TestValidateCmdLineFlags(t *testing.T) {
 	opts1 := &options{BucketName: "example_bucket", Source: "test/output", CacheFile: "test/.go3up.txt", Region: "us-west-1"}
 	if err := validateCmdLineFlags(opts1); err != nil {
@@ -54,31 +46,6 @@ func TestValidateCmdLineFlag(t *testing.T) {
 	}
 }
 
-func fakeUploaderGen(opts ...int) (uploader, *[]*sourceFile) {
-	errorKind, m := noError, sync.Mutex{}
-	if len(opts) > 0 {
-		errorKind = opts[0]
-	}
-
-	out := &[]*sourceFile{}
-	fn := func(src *sourceFile) error {
-		m.Lock()
-		*out = append(*out, src)
-		m.Unlock()
-
-		switch errorKind {
-		case noError:
-			return nil
-		case recoverableError:
-			return errors.New("Something something. " + recoverableErrorsSuffixes[0])
-		default:
-			return errors.New("Some made up error")
-		}
-	}
-
-	return fn, out
-}
-
 var _ = func() bool {
 	testing.Init()
 	return true
@@ -88,12 +55,5 @@ func init() {
 	opts.BucketName = "example_bucket"
 	opts.Source = "test/output"
 	opts.CacheFile = "test/.go3up.txt"
-	fakeBuffer := &bytes.Buffer{}
-	sayLock := &sync.Mutex{}
-	sayFn := loggerGen(fakeBuffer)
-	say = func(msg ...string) {
-		sayLock.Lock()
-		defer sayLock.Unlock()
-		sayFn(msg...)
-	}
+	logger = slog.New(newCompactHandler(&bytes.Buffer{}, slog.LevelInfo))
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracerProvider is the global tracer provider, nil if tracing is disabled.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing turns tracing on if o.TraceOTLP or o.TraceFile is set, exporting the spans over
+// OTLP/HTTP to the former and/or as JSON lines to the latter. The spans are exported in batches,
+// see shutdownTracing. The trace file is left open for the lifetime of the program.
+func setupTracing(o *options) error {
+	if o.TraceOTLP == "" && o.TraceFile == "" {
+		return nil
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", "go-s3-uploader"),
+		attribute.String("service.version", Version))
+	tpOpts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if o.TraceOTLP != "" {
+		exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(o.TraceOTLP))
+		if err != nil {
+			return err
+		}
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(exp))
+	}
+
+	if o.TraceFile != "" {
+		f, err := os.OpenFile(o.TraceFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) // #nosec G302 G304 - user given trace file
+		if err != nil {
+			return err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return errors.Join(err, f.Close())
+		}
+		tpOpts = append(tpOpts, sdktrace.WithBatcher(exp))
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(tpOpts...)
+	otel.SetTracerProvider(tracerProvider)
+
+	return nil
+}
+
+// shutdownTracing exports the spans left, if tracing is on.
+func shutdownTracing() error {
+	if tracerProvider == nil {
+		return nil
+	}
+
+	return tracerProvider.Shutdown(context.Background())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+
+	"github.com/petems/go-s3-uploader/pkg/uploader"
+)
+
+func TestTraceFile(t *testing.T) {
+	saved, prev := *opts, otel.GetTracerProvider()
+	defer func() {
+		*opts, tracerProvider = saved, nil
+		otel.SetTracerProvider(prev)
+	}()
+	opts.CacheFile = filepath.Join(t.TempDir(), "cache.txt")
+	opts.TraceFile = filepath.Join(t.TempDir(), "trace.json")
+
+	s3Uploader = uploader.NewMockS3Uploader()
+	defer func() { s3Uploader = nil }()
+
+	if err := setupTracing(opts); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	syncer, err := newSyncer()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, err := syncer.Run(context.Background()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if err := shutdownTracing(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	buf, err := os.ReadFile(opts.TraceFile)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for _, name := range []string{"sync", "hash", "S3Uploader.Upload", "cache.save"} {
+		if !strings.Contains(string(buf), `"Name":"`+name+`"`) {
+			t.Errorf("Expected a %s span in the trace file, got:\n%s", name, buf)
+		}
+	}
+}
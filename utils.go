@@ -1,63 +0,0 @@
-package main
-
-import (
-	"bytes"
-	"fmt"
-	"strings"
-)
-
-// S3 errors that we will retry.
-var recoverableErrorsSuffixes = []string{
-	"Idle connections will be closed.",
-	"EOF",
-	"broken pipe",
-	"no such host",
-	"transport closed before response was received",
-	"TLS handshake timeout",
-}
-
-func loggerGen(buffers ...*bytes.Buffer) func(msgs ...string) {
-	return func(msgs ...string) {
-		m := msg(msgs...)
-
-		if len(buffers) > 0 {
-			_, _ = buffers[0].WriteString(m)
-			return
-		}
-
-		fmt.Print(m)
-	}
-}
-
-// isRecoverable verifies if the error given is in recoverableErrorsSuffixes list.
-func isRecoverable(err error) bool {
-	for _, errSuffix := range recoverableErrorsSuffixes {
-		if strings.HasSuffix(err.Error(), errSuffix) {
-			return true
-		}
-	}
-
-	return false
-}
-
-// msg accepts 3 messages, corresponding to (in order): verbose, normal, quiet,
-// and returns one of them based on the opts.verbose and opts.quiet flags.
-//
-// If the message for a respective state is blank, nothing will be printed,
-// except if message for normal is missing. In that case, the verbose message
-// will be printed if available.
-func msg(msgs ...string) string {
-	switch {
-	case opts.verbose && len(msgs) > 0:
-		return msgs[0] + "\n"
-	case opts.quiet:
-		if len(msgs) > 2 {
-			return msgs[2]
-		}
-		return ""
-	case len(msgs) > 1:
-		return msgs[1]
-	default:
-		return ""
-	}
-}